@@ -5,19 +5,37 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"vim-arcade.theprimeagen.com/pkg/api"
 	"vim-arcade.theprimeagen.com/pkg/assert"
 	"vim-arcade.theprimeagen.com/pkg/ctrlc"
 	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	"vim-arcade.theprimeagen.com/pkg/packet"
 	prettylog "vim-arcade.theprimeagen.com/pkg/pretty-log"
+	tlsconfig "vim-arcade.theprimeagen.com/pkg/tls-config"
 )
 
 func getId() string {
     return os.Getenv("ID")
 }
 
+// LocalServers passes the game it launched this binary for
+func getGame() gameserverstats.GameType {
+    version, err := strconv.Atoi(os.Getenv("GAME_VERSION"))
+    if err != nil {
+        version = 0
+    }
+
+    game := gameserverstats.GameType{
+        Name: os.Getenv("GAME_TYPE"),
+        Version: uint16(version),
+    }
+    return game.OrDefault()
+}
+
 func main() {
     godotenv.Load()
 
@@ -39,17 +57,37 @@ func main() {
     db.SetSqliteModes()
     host, port := api.GetHostAndPort()
 
+    game := getGame()
     config := gameserverstats.GameServerConfig {
         State: gameserverstats.GSStateReady,
         Connections: 0,
         Load: 0,
         Id: getId(),
+        GameType: game.Name,
+        GameVersion: game.Version,
         Host: host,
         Port: port,
+        Region: os.Getenv("REGION"),
     }
 
-    ll.Info("creating server", "port", port, "host", host)
+    ll.Info("creating server", "port", port, "host", host, "game", game.String())
     server := api.NewGameServerRunner(db, config)
+    checksums, _ := strconv.Atoi(os.Getenv("PACKET_CHECKSUMS"))
+    server.WithIntegrity([]byte(os.Getenv("GAME_SERVER_SECRET")), checksums > 0)
+
+    // the same files the proxy dials with, see AMProxyConfig
+    if certFile := os.Getenv("GAME_SERVER_TLS_CERT_FILE"); certFile != "" {
+        tlsConfig, err := tlsconfig.ServerConfig(certFile, os.Getenv("GAME_SERVER_TLS_KEY_FILE"), os.Getenv("GAME_SERVER_TLS_CA_FILE"))
+        assert.NoError(err, "unable to create game server tls")
+        server.WithTLS(tlsConfig)
+    }
+
+    heartbeatMS, _ := strconv.Atoi(os.Getenv("HEARTBEAT_INTERVAL_MS"))
+    heartbeatMissed, _ := strconv.Atoi(os.Getenv("HEARTBEAT_MAX_MISSED"))
+    server.WithHeartbeat(packet.HeartbeatConfig{
+        Interval: time.Duration(heartbeatMS) * time.Millisecond,
+        MaxMissed: heartbeatMissed,
+    })
     ctx, cancel := context.WithCancel(context.Background())
     ctrlc.HandleCtrlC(cancel)
 
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/assert"
 	"vim-arcade.theprimeagen.com/pkg/ctrlc"
 	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
 	"vim-arcade.theprimeagen.com/pkg/pretty-log"
@@ -35,20 +39,68 @@ func main() {
 
     db := gameserverstats.NewSqlite("file:/tmp/sim.db")
     db.SetSqliteModes()
-    local := servermanagement.NewLocalServers(db, servermanagement.ServerParams{
-        MaxLoad: 0.9,
-    })
-    mm := matchmaking.NewMatchMakingServer(matchmaking.MatchMakingServerParams{
-        Port: port,
-        GameServer: &local,
-    })
+    params, err := servermanagement.ServerParamsFromEnv(0.9)
+    assert.NoError(err, "invalid server params")
+    local := servermanagement.NewLocalServers(db, params)
 
     ctx, cancel := context.WithCancel(context.Background())
-    ctrlc.HandleCtrlC(cancel)
+
+    config := amproxy.AMProxyConfigFromEnv()
+    auth, err := config.Authenticator()
+    assert.NoError(err, "unable to create authenticator")
+
+    gameServers, err := config.GameServerConnections()
+    assert.NoError(err, "unable to create game server tls")
+    listenerTLS, err := config.ListenerTLS()
+    assert.NoError(err, "unable to create listener tls")
+
+    proxy := amproxy.NewAMProxy(ctx, &local, gameServers, auth)
+    proxy.WithGameServerIntegrity([]byte(config.GameServerSecret), config.PacketChecksums)
+    proxy.WithHeartbeat(config.Heartbeat())
+    proxy.WithAuthTimeout(time.Duration(config.AuthTimeoutMS) * time.Millisecond)
+    proxy.WithConnectionLimits(config.Limits())
+    proxy.WithMatchmakingQueue(config.Queue())
+    proxy.WithPartyTimeout(time.Duration(config.PartyTimeoutMS) * time.Millisecond)
+    proxy.WithResumeGracePeriod(time.Duration(config.ResumeGraceMS) * time.Millisecond)
+    tcpProxy := amproxy.NewTCPProxy(&proxy, uint16(port))
+    tcpProxy.WithTLS(listenerTLS)
+    wsProxy := amproxy.NewWebSocketProxy(&proxy, uint16(config.WebSocketPort))
+
+    ctrlc.HandleCtrlCWithShutdown(cancel, func() error {
+        drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Duration(config.DrainTimeoutMS) * time.Millisecond)
+        defer drainCancel()
+
+        // both drain the same proxy, the second only stops accepting
+        var wsErr error
+        if config.WebSocketPort > 0 {
+            wsErr = wsProxy.Drain(drainCtx)
+        }
+        return errors.Join(wsErr, tcpProxy.Drain(drainCtx))
+    })
+
+    if config.WebSocketPort > 0 {
+        go func() {
+            logger.Warn("websocket finished", "error", wsProxy.Run(ctx))
+        }()
+    }
+
+    if config.MetricsPort > 0 {
+        metrics := amproxy.NewMetricsServer(&proxy, db, uint16(config.MetricsPort))
+        go func() {
+            logger.Warn("metrics finished", "error", metrics.Run(ctx))
+        }()
+    }
+
+    if config.AdminPort > 0 && config.AdminToken != "" {
+        admin := amproxy.NewAdminServer(&proxy, db, config.AdminToken, uint16(config.AdminPort))
+        go func() {
+            logger.Warn("admin finished", "error", admin.Run(ctx))
+        }()
+    }
 
     go db.Run(ctx)
-    err = mm.Run(ctx)
+    go local.Run(ctx)
+    tcpProxy.Run(ctx)
 
-    logger.Warn("mm main finished", "error", err)
+    logger.Warn("mm main finished")
 }
-
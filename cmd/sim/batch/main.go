@@ -17,7 +17,7 @@ func main() {
     logger.Info("Welcome to costco", "count", 15)
 
     ctx, cancel := context.WithCancel(context.Background())
-    sim.KillContext(cancel)
+    sim.KillContext(ctx, cancel)
 
     cwd, err := os.Getwd()
     assert.NoError(err, "unable to get cwd")
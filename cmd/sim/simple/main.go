@@ -18,7 +18,7 @@ func main() {
     logger := sim.CreateLogger("simple-sim")
 
     ctx, cancel := context.WithCancel(context.Background())
-    sim.KillContext(cancel)
+    sim.KillContext(ctx, cancel)
 
     cwd, err := os.Getwd()
     assert.NoError(err, "unable to get cwd")
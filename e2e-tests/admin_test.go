@@ -0,0 +1,127 @@
+package e2etests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+type adminClient struct {
+    t *testing.T
+    url string
+    token string
+}
+
+func (a *adminClient) do(method string, path string, out any) int {
+    req, err := http.NewRequest(method, a.url + path, nil)
+    require.NoError(a.t, err)
+    if a.token != "" {
+        req.Header.Set("Authorization", "Bearer " + a.token)
+    }
+
+    rsp, err := http.DefaultClient.Do(req)
+    require.NoError(a.t, err)
+    defer rsp.Body.Close()
+
+    if out != nil && rsp.StatusCode < 300 {
+        require.NoError(a.t, json.NewDecoder(rsp.Body).Decode(out))
+    }
+    return rsp.StatusCode
+}
+
+func TestAdminAPI(t *testing.T) {
+    sim.CreateLogger("TestAdminAPI")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    adminPort, err := api.GetFreePort()
+    require.NoError(t, err)
+    t.Setenv("ADMIN_PORT", fmt.Sprintf("%d", adminPort))
+    t.Setenv("ADMIN_TOKEN", "hunter2")
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    url := fmt.Sprintf("http://127.0.0.1:%d", adminPort)
+    admin := adminClient{t: t, url: url, token: "hunter2"}
+    require.Equal(t, http.StatusUnauthorized, (&adminClient{t: t, url: url}).do("GET", "/servers", nil))
+    require.Equal(t, http.StatusUnauthorized, (&adminClient{t: t, url: url, token: "nope"}).do("GET", "/servers", nil))
+
+    client := state.Factory.New()
+    sim.AssertConnectionsOnProxy(&state, 1)
+
+    var conns []amproxy.AMConnectionInfo
+    require.Equal(t, http.StatusOK, admin.do("GET", "/connections", &conns))
+    require.Len(t, conns, 1)
+    require.Equal(t, client.ServerId, conns[0].GameServerId)
+    require.Equal(t, client.Id(), conns[0].ClientId)
+    host, _, err := net.SplitHostPort(conns[0].Addr)
+    require.NoError(t, err)
+    require.Equal(t, "127.0.0.1", host)
+
+    var servers []amproxy.AdminServerInfo
+    require.Equal(t, http.StatusOK, admin.do("GET", "/servers", &servers))
+    require.Len(t, servers, 1)
+    require.Equal(t, client.ServerId, servers[0].Id)
+    require.False(t, servers[0].Draining)
+
+    // draining the only server forces the next client onto a new one
+    require.Equal(t, http.StatusNotFound, admin.do("POST", "/servers/nope/drain", nil))
+    require.Equal(t, http.StatusOK, admin.do("POST", fmt.Sprintf("/servers/%s/drain", client.ServerId), nil))
+    require.Equal(t, http.StatusOK, admin.do("GET", "/servers", &servers))
+    require.True(t, servers[0].Draining)
+
+    other := state.Factory.New()
+    require.NotEqual(t, client.ServerId, other.ServerId)
+    sim.AssertConnectionsOnProxy(&state, 2)
+    require.Equal(t, http.StatusOK, admin.do("GET", "/connections", &conns))
+    require.Len(t, conns, 2)
+    require.NotEqual(t, conns[0].Id, conns[1].Id)
+    require.Equal(t, other.Id(), conns[1].ClientId)
+
+    require.Equal(t, http.StatusNotFound, admin.do("DELETE", "/connections/nope", nil))
+    require.Equal(t, http.StatusNoContent, admin.do("DELETE", fmt.Sprintf("/connections/%s", conns[0].Id), nil))
+    client.WaitForDone()
+    sim.AssertConnectionsOnProxy(&state, 1)
+
+    var created map[string]string
+    require.Equal(t, http.StatusCreated, admin.do("POST", "/servers", &created))
+    require.NotEmpty(t, created["id"])
+    state.Server.WaitForReady(ctx, created["id"])
+    require.Equal(t, http.StatusOK, admin.do("GET", "/servers", &servers))
+    require.Len(t, servers, 3)
+}
+
+func TestAdminCreateServerRespectsLimits(t *testing.T) {
+    sim.CreateLogger("TestAdminCreateServerRespectsLimits")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    adminPort, err := api.GetFreePort()
+    require.NoError(t, err)
+    t.Setenv("ADMIN_PORT", fmt.Sprintf("%d", adminPort))
+    t.Setenv("ADMIN_TOKEN", "hunter2")
+
+    path := sim.GetDBPath("no_server")
+    sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+        MaxCreatesPerMinute: 1,
+    })
+    t.Cleanup(func() {cancel()})
+
+    admin := adminClient{t: t, url: fmt.Sprintf("http://127.0.0.1:%d", adminPort), token: "hunter2"}
+    require.Equal(t, http.StatusCreated, admin.do("POST", "/servers", nil))
+    require.Equal(t, http.StatusTooManyRequests, admin.do("POST", "/servers", nil))
+}
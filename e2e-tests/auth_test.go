@@ -0,0 +1,115 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func TestAuthRejectsClientWithoutToken(t *testing.T) {
+    sim.CreateLogger("TestAuthRejectsClientWithoutToken")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("AUTH_SECRET", "shh")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    client := api.NewClient("0.0.0.0", uint16(state.Port), [16]byte{0x69})
+    err := client.Connect(ctx)
+    require.ErrorIs(t, err, api.ClientAuthRejected)
+    require.Equal(t, api.CSDisconnected, client.State)
+
+    // the factory mints tokens from the same secret
+    authed := state.Factory.New()
+    sim.AssertClient(&state, authed)
+    sim.AssertConnectionCount(&state, gameserverstats.GameServecConfigConnectionStats{
+        Connections: 1,
+        ConnectionsAdded: 1,
+        ConnectionsRemoved: 0,
+    }, time.Second * 5)
+}
+
+func TestAuthTimeoutClosesSilentSocket(t *testing.T) {
+    sim.CreateLogger("TestAuthTimeoutClosesSilentSocket")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("AUTH_TIMEOUT_MS", "100")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    conn, err := net.Dial("tcp4", fmt.Sprintf("0.0.0.0:%d", state.Port))
+    require.NoError(t, err)
+    defer conn.Close()
+
+    // never say a word, the proxy should give up on us
+    require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+    framer := packet.NewPacketFramer()
+    go packet.FrameWithReader(&framer, conn)
+
+    pkt, ok := <-framer.C
+    require.True(t, ok, "expected an error packet before the close")
+    require.Equal(t, packet.PacketError, pkt.Type())
+    require.Equal(t, amproxy.AMProxyAuthTimeout.Error(), string(pkt.Data()))
+
+    _, ok = <-framer.C
+    require.False(t, ok, "expected the proxy to close the connection")
+    require.NoError(t, <-framer.Err, "expected EOF, not the read deadline")
+
+    require.Equal(t, 1, state.AMProxy.Stats().AuthTimeouts)
+}
+
+func TestAuthSurvivesUnknownPacketType(t *testing.T) {
+    sim.CreateLogger("TestAuthSurvivesUnknownPacketType")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    conn, err := net.Dial("tcp4", fmt.Sprintf("0.0.0.0:%d", state.Port))
+    require.NoError(t, err)
+    defer conn.Close()
+    require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+    framer := packet.NewPacketFramer()
+    go packet.FrameWithReader(&framer, conn)
+
+    // a type nobody has given a name to
+    pkt, err := packet.PacketFromParts(62, packet.EncodingBytes, []byte("hello?"))
+    require.NoError(t, err)
+    _, err = pkt.Into(conn)
+    require.NoError(t, err)
+
+    rsp, ok := <-framer.C
+    require.True(t, ok, "expected a rejection before the close")
+    require.Equal(t, packet.PacketServerAuthResponse, rsp.Type())
+    require.Equal(t, byte(0), rsp.Data()[0])
+    require.Equal(t, 1, state.AMProxy.Stats().AuthFailures)
+
+    // and the proxy is still there for everyone else
+    client := state.Factory.New()
+    sim.AssertClient(&state, client)
+    sim.AssertConnectionsOnProxy(&state, 1)
+}
@@ -0,0 +1,58 @@
+package e2etests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func readyServers(state *sim.ServerState) (ready int, empty int) {
+    configs, err := state.Sqlite.GetAllGameServerConfigs()
+    if err != nil {
+        return 0, 0
+    }
+
+    for _, c := range configs {
+        if c.State == gameserverstats.GSStateReady {
+            ready++
+            if c.Connections == 0 {
+                empty++
+            }
+        }
+    }
+    return ready, empty
+}
+
+func TestAutoscalerKeepsWarmPool(t *testing.T) {
+    sim.CreateLogger("TestAutoscalerKeepsWarmPool")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.0005,
+        MinWarmServers: 1,
+        RefreshInterval: time.Millisecond * 100,
+    })
+    t.Cleanup(func() {cancel()})
+
+    // nobody has connected and there is already a server waiting
+    require.Eventually(t, func() bool {
+        ready, empty := readyServers(&state)
+        return ready == 1 && empty == 1
+    }, time.Second * 3, time.Millisecond * 50)
+
+    client := state.Factory.New()
+    waitForServerConnections(t, &state, client.ServerId, 1)
+
+    // the client took the warm server, so another one is made
+    require.Eventually(t, func() bool {
+        ready, empty := readyServers(&state)
+        return ready == 2 && empty == 1
+    }, time.Second * 3, time.Millisecond * 50)
+}
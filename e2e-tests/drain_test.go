@@ -0,0 +1,50 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func TestDrainClosesConnectionsGracefully(t *testing.T) {
+    sim.CreateLogger("TestDrainClosesConnectionsGracefully")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    clients := state.Factory.CreateBatchedConnections(5)
+    sim.AssertConnectionsOnProxy(&state, 5)
+
+    drainCtx, drainCancel := context.WithTimeout(ctx, time.Second)
+    defer drainCancel()
+    require.NoError(t, state.Proxy.Drain(drainCtx))
+
+    for _, c := range clients {
+        c.WaitForDone()
+        require.Equal(t, api.CSDisconnected, c.State)
+    }
+    sim.AssertConnectionsOnProxy(&state, 0)
+
+    // the game server was told, it didn't just see the socket go away
+    sim.AssertConnectionCount(&state, gameserverstats.GameServecConfigConnectionStats{
+        Connections: 0,
+        ConnectionsAdded: 5,
+        ConnectionsRemoved: 5,
+    }, time.Second * 2)
+
+    _, err := net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", state.Port))
+    require.Error(t, err, "the proxy should no longer be accepting")
+}
@@ -0,0 +1,54 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func TestMatchmakingNeverMixesGames(t *testing.T) {
+    sim.CreateLogger("TestMatchmakingNeverMixesGames")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    // the dummy server hosts whatever game it is told to
+    other := gameserverstats.GameType{Name: "other", Version: 2}
+    t.Setenv("GAME_SERVERS", fmt.Sprintf("%s=%s", other.String(), os.Getenv("GAME_SERVER")))
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    first := state.Factory.New()
+    waitForServerConnections(t, &state, first.ServerId, 1)
+
+    second := state.Factory.NewClient()
+    second.WithGame(other.Name, other.Version)
+    require.NoError(t, second.Connect(ctx))
+    require.Equal(t, api.CSConnected, second.State)
+    waitForServerConnections(t, &state, second.ServerId, 1)
+
+    // plenty of room on the first server, but it is the wrong game
+    require.NotEqual(t, first.ServerId, second.ServerId)
+    require.Equal(t, gameserverstats.DefaultGame, state.Sqlite.GetById(first.ServerId).Game())
+    require.Equal(t, other, state.Sqlite.GetById(second.ServerId).Game())
+
+    third := state.Factory.NewClient()
+    third.WithGame(other.Name, other.Version)
+    require.NoError(t, third.Connect(ctx))
+    require.Equal(t, second.ServerId, third.ServerId)
+
+    unknown := state.Factory.NewClient()
+    unknown.WithGame(other.Name, other.Version + 1)
+    err := unknown.Connect(ctx)
+    require.ErrorContains(t, err, servermanagement.UnknownGameType.Error())
+}
@@ -0,0 +1,65 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func TestMaxConnectionsPerIPDisallows(t *testing.T) {
+    sim.CreateLogger("TestMaxConnectionsPerIPDisallows")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("MAX_CONNECTIONS_PER_IP", "1")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    addr := fmt.Sprintf("127.0.0.1:%d", state.Port)
+    first, err := net.Dial("tcp4", addr)
+    require.NoError(t, err)
+    defer first.Close()
+
+    // the first connection sits in auth, holding the only slot for this ip
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().ActiveConnections == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    second, err := net.Dial("tcp4", addr)
+    require.NoError(t, err)
+    defer second.Close()
+    require.NoError(t, second.SetReadDeadline(time.Now().Add(time.Second)))
+
+    framer := packet.NewPacketFramer()
+    go packet.FrameWithReader(&framer, second)
+
+    pkt, ok := <-framer.C
+    require.True(t, ok, "expected an error packet before the close")
+    require.Equal(t, packet.PacketError, pkt.Type())
+    require.Equal(t, amproxy.AMProxyDisallowed.Error(), string(pkt.Data()))
+
+    _, ok = <-framer.C
+    require.False(t, ok, "expected the proxy to close the connection")
+
+    stats := state.AMProxy.Stats()
+    require.Equal(t, 1, stats.AddressLimited)
+    require.Equal(t, 1, stats.Limits.MaxPerIP)
+    require.Equal(t, 1, stats.ActiveConnections)
+
+    // closing the first gives the slot back
+    first.Close()
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().ActiveConnections == 0
+    }, time.Second, 10 * time.Millisecond)
+}
@@ -1,19 +1,22 @@
 package e2etests
 
 import (
+	"bytes"
 	"context"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
 	"vim-arcade.theprimeagen.com/e2e-tests/sim"
 	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	"vim-arcade.theprimeagen.com/pkg/packet"
 	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
 )
 
 func TestMatchMakingCreateServer(t *testing.T) {
     logger := sim.CreateLogger("TestMatchMakingCreateServer")
     ctx, cancel := context.WithCancel(context.Background())
-    sim.KillContext(cancel)
+    sim.KillContext(ctx, cancel)
 
     path := sim.GetDBPath("no_server")
     state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
@@ -27,6 +30,7 @@ func TestMatchMakingCreateServer(t *testing.T) {
     t.Cleanup(func() {cancel()})
 
     sim.AssertClient(&state, client);
+    sim.AssertConnectionsOnProxy(&state, 1)
     sim.AssertConnectionCount(&state, gameserverstats.GameServecConfigConnectionStats{
         Connections: 1,
         ConnectionsAdded: 1,
@@ -37,7 +41,7 @@ func TestMatchMakingCreateServer(t *testing.T) {
 func TestMakingServerWithBatchRequest(t *testing.T) {
     sim.CreateLogger("TestMakingServerWithBatchRequest")
     ctx, cancel := context.WithCancel(context.Background())
-    sim.KillContext(cancel)
+    sim.KillContext(ctx, cancel)
 
     path := sim.GetDBPath("no_server")
     state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
@@ -49,9 +53,67 @@ func TestMakingServerWithBatchRequest(t *testing.T) {
 
     sim.AssertClients(&state, clients);
     sim.AssertAllClientsSameServer(&state, clients);
+    sim.AssertConnectionsOnProxy(&state, 15)
     sim.AssertConnectionCount(&state, gameserverstats.GameServecConfigConnectionStats{
         Connections: 15,
         ConnectionsAdded: 15,
         ConnectionsRemoved: 0,
     }, time.Second * 5)
 }
+
+func TestProxyStatsCountForwardedPackets(t *testing.T) {
+    sim.CreateLogger("TestProxyStatsCountForwardedPackets")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    client := state.Factory.New()
+    sim.AssertConnectionsOnProxy(&state, 1)
+
+    msg, err := packet.CreateMessage("hello")
+    require.NoError(t, err)
+    buf := bytes.NewBuffer(nil)
+    _, err = msg.Into(buf)
+    require.NoError(t, err)
+    require.NoError(t, client.Write(buf.Bytes()))
+    client.Disconnect()
+    sim.AssertConnectionsOnProxy(&state, 0)
+
+    stats := state.AMProxy.Stats()
+    require.Equal(t, 1, stats.TotalConnections)
+    require.Equal(t, 0, stats.Errors)
+    require.Equal(t, 0, stats.AuthFailures)
+    require.Equal(t, 0, stats.MatchmakingFailures)
+
+    // the message and the close, which is only a header
+    require.Equal(t, uint64(2), stats.ClientToGamePackets)
+    require.Equal(t, uint64(buf.Len() + packet.HEADER_SIZE), stats.ClientToGameBytes)
+}
+
+func TestBatchRequestCreatesServersByCapacity(t *testing.T) {
+    sim.CreateLogger("TestBatchRequestCreatesServersByCapacity")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    // one connection per server
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.0005,
+    })
+    t.Cleanup(func() {cancel()})
+
+    clients := state.Factory.CreateBatchedConnections(3)
+
+    servers := map[string]struct{}{}
+    for _, c := range clients {
+        servers[c.ServerId] = struct{}{}
+    }
+    require.Len(t, servers, 3, "every client should have gotten its own server")
+    sim.AssertClients(&state, clients)
+    sim.AssertConnectionsOnProxy(&state, 3)
+}
@@ -0,0 +1,52 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+    sim.CreateLogger("TestMetricsEndpoint")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    metricsPort, err := api.GetFreePort()
+    require.NoError(t, err)
+    t.Setenv("METRICS_PORT", fmt.Sprintf("%d", metricsPort))
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    client := state.Factory.New()
+    sim.AssertConnectionsOnProxy(&state, 1)
+
+    rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", metricsPort))
+    require.NoError(t, err)
+    defer rsp.Body.Close()
+    require.Equal(t, http.StatusOK, rsp.StatusCode)
+    require.Contains(t, rsp.Header.Get("Content-Type"), "text/plain")
+
+    body, err := io.ReadAll(rsp.Body)
+    require.NoError(t, err)
+    metrics := string(body)
+
+    require.Contains(t, metrics, "# TYPE vim_arcade_proxy_connections_active gauge\n")
+    require.Contains(t, metrics, "vim_arcade_proxy_connections_active 1\n")
+    require.Contains(t, metrics, "vim_arcade_proxy_connections_total 1\n")
+    require.Contains(t, metrics, "vim_arcade_proxy_auth_seconds_count 1\n")
+    require.Contains(t, metrics, "vim_arcade_proxy_matchmake_seconds_count 1\n")
+    require.Contains(t, metrics, "vim_arcade_proxy_server_creation_wait_seconds_count 1\n")
+    require.Contains(t, metrics, "vim_arcade_game_servers 1\n")
+    require.Contains(t, metrics, fmt.Sprintf("vim_arcade_game_server_connections{id=\"%s\"", client.ServerId))
+}
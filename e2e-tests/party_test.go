@@ -0,0 +1,98 @@
+package e2etests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+// three connections per server
+var threeSlots = servermanagement.ServerParams{
+    MaxLoad: 0.003,
+}
+
+func partyClient(state *sim.ServerState, party string, size uint8) *api.Client {
+    client := state.Factory.NewClient()
+    client.WithParty(party, size)
+    return client
+}
+
+func TestPartyLandsOnOneServer(t *testing.T) {
+    sim.CreateLogger("TestPartyLandsOnOneServer")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, threeSlots)
+    t.Cleanup(func() {cancel()})
+
+    solo := state.Factory.New()
+    waitForServerConnections(t, &state, solo.ServerId, 1)
+
+    members := []*api.Client{
+        partyClient(&state, "the-boys", 3),
+        partyClient(&state, "the-boys", 3),
+    }
+    done := []<-chan error{
+        connectAsync(ctx, members[0]),
+        connectAsync(ctx, members[1]),
+    }
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().PartiesWaiting == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    // nobody gets a server until everyone is here
+    select {
+    case err := <-done[0]:
+        require.FailNow(t, "party member connected before the party filled up", "error", err)
+    case <-time.After(time.Millisecond * 100):
+    }
+
+    last := partyClient(&state, "the-boys", 3)
+    require.NoError(t, last.Connect(ctx))
+    for _, d := range done {
+        require.NoError(t, <-d)
+    }
+    members = append(members, last)
+
+    // the solo server has room for two more, not three
+    for _, m := range members {
+        require.Equal(t, last.ServerId, m.ServerId)
+    }
+    require.NotEqual(t, solo.ServerId, last.ServerId)
+    waitForServerConnections(t, &state, last.ServerId, 3)
+    require.Equal(t, 0, state.AMProxy.Stats().PartiesWaiting)
+}
+
+func TestPartyFailures(t *testing.T) {
+    sim.CreateLogger("TestPartyFailures")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("PARTY_TIMEOUT_MS", "300")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, threeSlots)
+    t.Cleanup(func() {cancel()})
+
+    err := partyClient(&state, "huge", 4).Connect(ctx)
+    require.ErrorContains(t, err, amproxy.MatchmakingPartyTooLarge.Error())
+
+    first := partyClient(&state, "lonely", 2)
+    done := connectAsync(ctx, first)
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().PartiesWaiting == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    err = partyClient(&state, "lonely", 3).Connect(ctx)
+    require.ErrorContains(t, err, amproxy.MatchmakingPartyMismatch.Error())
+
+    require.ErrorContains(t, <-done, amproxy.MatchmakingPartyTimeout.Error())
+    require.Equal(t, 0, state.AMProxy.Stats().PartiesWaiting)
+    sim.AssertConnectionsOnProxy(&state, 0)
+}
@@ -0,0 +1,105 @@
+package e2etests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+// one connection per server, one server
+var singleSlot = servermanagement.ServerParams{
+    MaxLoad: 0.0005,
+    MaxServers: 1,
+}
+
+func connectAsync(ctx context.Context, client *api.Client) <-chan error {
+    done := make(chan error, 1)
+    go func() {
+        done <- client.Connect(ctx)
+    }()
+    return done
+}
+
+func waitForServerConnections(t *testing.T, state *sim.ServerState, id string, count int) {
+    require.Eventually(t, func() bool {
+        config := state.Sqlite.GetById(id)
+        return config != nil && config.Connections == count
+    }, time.Second * 2, 10 * time.Millisecond)
+}
+
+func TestQueueAssignsWhenCapacityFrees(t *testing.T) {
+    sim.CreateLogger("TestQueueAssignsWhenCapacityFrees")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("MATCHMAKING_QUEUE_SIZE", "2")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, singleSlot)
+    t.Cleanup(func() {cancel()})
+
+    first := state.Factory.New()
+    waitForServerConnections(t, &state, first.ServerId, 1)
+
+    second := state.Factory.NewClient()
+    done := connectAsync(ctx, second)
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().QueuedConnections == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    first.Disconnect()
+
+    select {
+    case err := <-done:
+        require.NoError(t, err)
+    case <-time.After(time.Second * 2):
+        require.FailNow(t, "queued client was never assigned a server")
+    }
+
+    require.Equal(t, api.CSConnected, second.State)
+    require.Equal(t, first.ServerId, second.ServerId)
+    require.Equal(t, []string{"queued: position 1"}, second.Messages)
+    require.Equal(t, 0, state.AMProxy.Stats().QueuedConnections)
+    waitForServerConnections(t, &state, second.ServerId, 1)
+}
+
+func TestQueueFullAndTimeout(t *testing.T) {
+    sim.CreateLogger("TestQueueFullAndTimeout")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("MATCHMAKING_QUEUE_SIZE", "1")
+    t.Setenv("MATCHMAKING_QUEUE_TIMEOUT_MS", "500")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, singleSlot)
+    t.Cleanup(func() {cancel()})
+
+    first := state.Factory.New()
+    waitForServerConnections(t, &state, first.ServerId, 1)
+
+    second := state.Factory.NewClient()
+    done := connectAsync(ctx, second)
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().QueuedConnections == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    third := state.Factory.NewClient()
+    err := third.Connect(ctx)
+    require.ErrorContains(t, err, amproxy.MatchmakingQueueFull.Error())
+    require.Empty(t, third.Messages)
+
+    err = <-done
+    require.ErrorContains(t, err, amproxy.MatchmakingQueueTimeout.Error())
+    require.Equal(t, []string{"queued: position 1"}, second.Messages)
+    require.Equal(t, api.CSDisconnected, second.State)
+
+    stats := state.AMProxy.Stats()
+    require.Equal(t, 0, stats.QueuedConnections)
+    require.Equal(t, 2, stats.MatchmakingFailures)
+    sim.AssertConnectionsOnProxy(&state, 1)
+}
@@ -0,0 +1,137 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+// rawHandshake sends the first packet on a new connection and returns the
+// proxy's auth response
+func rawHandshake(t *testing.T, state *sim.ServerState, first packet.Packet) (net.Conn, *packet.Packet) {
+    conn, err := net.Dial("tcp4", fmt.Sprintf("0.0.0.0:%d", state.Port))
+    require.NoError(t, err)
+    require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second * 2)))
+
+    framer := packet.NewPacketFramer()
+    go packet.FrameWithReader(&framer, conn)
+
+    _, err = first.Into(conn)
+    require.NoError(t, err)
+
+    for pkt := range framer.C {
+        if pkt.Type() == packet.PacketServerAuthResponse {
+            return conn, pkt
+        }
+    }
+
+    require.FailNow(t, "connection closed before the auth response", "error", <-framer.Err)
+    return nil, nil
+}
+
+func TestResumeAfterDrop(t *testing.T) {
+    sim.CreateLogger("TestResumeAfterDrop")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("RESUME_GRACE_MS", "2000")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    client := state.Factory.NewClient()
+    client.WithReconnect(api.ReconnectConfig{
+        Attempts: 5,
+        Backoff: time.Millisecond * 50,
+        MaxBackoff: time.Millisecond * 200,
+    })
+    require.NoError(t, client.Connect(ctx))
+    serverId := client.ServerId
+    waitForServerConnections(t, &state, serverId, 1)
+
+    require.NoError(t, client.DropConnection())
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().Resumes == 1
+    }, time.Second * 2, 10 * time.Millisecond)
+
+    // same game server, and the game server never saw the drop
+    require.Equal(t, serverId, client.ServerId)
+    require.Equal(t, 0, state.AMProxy.Stats().ParkedConnections)
+    config := state.Sqlite.GetById(serverId)
+    require.Equal(t, 1, config.Connections)
+    require.Equal(t, 0, config.ConnectionsRemoved)
+    sim.AssertConnectionsOnProxy(&state, 1)
+
+    // the resumed connection is the one the game server is tied to
+    client.Disconnect()
+    client.WaitForDone()
+    waitForServerConnections(t, &state, serverId, 0)
+    sim.AssertConnectionsOnProxy(&state, 0)
+}
+
+func TestResumeRejectedAndExpires(t *testing.T) {
+    sim.CreateLogger("TestResumeRejectedAndExpires")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("RESUME_GRACE_MS", "300")
+    path := sim.GetDBPath("no_server")
+    state := sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+    t.Cleanup(func() {cancel()})
+
+    id := [16]byte{}
+    copy(id[:], "resume-rejected!")
+    conn, rsp := rawHandshake(t, &state, packet.CreateClientAuth(id[:]))
+    auth, err := packet.ParseServerAuth(rsp)
+    require.NoError(t, err)
+    require.True(t, auth.Accepted)
+    serverId := auth.GameId
+    token := append([]byte{}, auth.ResumeToken...)
+    require.Len(t, token, amproxy.RESUME_TOKEN_SIZE)
+    waitForServerConnections(t, &state, serverId, 1)
+
+    require.NoError(t, conn.Close())
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().ParkedConnections == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    // someone else's id cannot take the connection
+    other := [16]byte{}
+    copy(other[:], "resume-stealing!")
+    resume, err := packet.CreateClientResume(other[:], token)
+    require.NoError(t, err)
+    conn, rsp = rawHandshake(t, &state, resume)
+    defer conn.Close()
+    auth, err = packet.ParseServerAuth(rsp)
+    require.NoError(t, err)
+    require.False(t, auth.Accepted)
+    require.Equal(t, amproxy.AMProxyResumeInvalid.Error(), auth.Reason)
+
+    // the game server keeps the connection until the grace period is over
+    require.Equal(t, 1, state.Sqlite.GetById(serverId).Connections)
+    require.Eventually(t, func() bool {
+        return state.AMProxy.Stats().ParkedConnections == 0
+    }, time.Second * 2, 10 * time.Millisecond)
+    waitForServerConnections(t, &state, serverId, 0)
+    sim.AssertConnectionsOnProxy(&state, 0)
+
+    resume, err = packet.CreateClientResume(id[:], token)
+    require.NoError(t, err)
+    conn, rsp = rawHandshake(t, &state, resume)
+    defer conn.Close()
+    require.False(t, packet.ServerAuthAccepted(rsp))
+    require.Equal(t, 0, state.AMProxy.Stats().Resumes)
+}
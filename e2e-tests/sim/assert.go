@@ -40,6 +40,18 @@ func AssertClient(state *ServerState, client *api.Client) {
 
 func AssertConnectionsOnProxy(state *ServerState, count int) {
     slog.Info("AssertConnectionsOnProxy", "count", count)
+
+    // connections are removed by the proxy's goroutines, give them a moment
+    start := time.Now()
+    for time.Now().Sub(start) < time.Second {
+        if state.AMProxy.Stats().ActiveConnections == count {
+            break
+        }
+        time.Sleep(time.Millisecond * 10)
+    }
+
+    stats := state.AMProxy.Stats()
+    assert.Assert(stats.ActiveConnections == count, "expected proxy connection count to match", "expected", count, "received", stats.ActiveConnections, "stats", stats.String())
 }
 
 func AssertServerStats(state *ServerState, stats gameserverstats.GameServerConfig, dur time.Duration) {
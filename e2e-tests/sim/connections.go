@@ -2,6 +2,7 @@ package sim
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"log/slog"
 	"math/rand"
@@ -136,6 +137,12 @@ type TestingClientFactory struct {
 	host   string
 	port   uint16
 	logger *slog.Logger
+
+	// mints a token per client when the proxy authenticates
+	tokens func(id []byte) []byte
+
+	// every client dials with it when the proxy listens with tls
+	tls *tls.Config
 }
 
 func NewTestingClientFactory(host string, port uint16, logger *slog.Logger) TestingClientFactory {
@@ -160,6 +167,7 @@ func (f *TestingClientFactory) CreateBatchedConnectionsWithWait(count int, wait
 
 func (f *TestingClientFactory) CreateBatchedConnections(count int) []*api.Client {
 	wait := &sync.WaitGroup{}
+	wait.Add(count)
 	clients := f.CreateBatchedConnectionsWithWait(count, wait)
 
 	f.logger.Info("CreateBatchedConnections waiting", "count", count)
@@ -168,23 +176,45 @@ func (f *TestingClientFactory) CreateBatchedConnections(count int) []*api.Client
 	return clients
 }
 
+func (f *TestingClientFactory) WithTokens(tokens func(id []byte) []byte) {
+	f.tokens = tokens
+}
+
+func (f *TestingClientFactory) WithTLS(config *tls.Config) {
+	f.tls = config
+}
+
+// NewClient is a client that has yet to connect
+func (f *TestingClientFactory) NewClient() *api.Client {
+	id := getNextId()
+	client := api.NewClient(f.host, f.port, id)
+	if f.tokens != nil {
+		client.WithToken(f.tokens(id[:]))
+	}
+	if f.tls != nil {
+		client.WithTLS(f.tls)
+	}
+	return &client
+}
+
 func (f TestingClientFactory) WithPort(port uint16) TestingClientFactory {
 	f.port = port
 	return f
 }
 
 func (f *TestingClientFactory) New() *api.Client {
-	client := api.NewClient(f.host, f.port, getNextId())
+	client := f.NewClient()
 	f.logger.Info("factory connecting", "id", client.Id())
-	client.Connect(context.Background())
+	err := client.Connect(context.Background())
+	assert.NoError(err, "unable to connect to mm", "id", client.Id())
     client.WaitForReady()
 	f.logger.Info("factory connected", "id", client.Id())
-	return &client
+	return client
 }
 
 // this is getting hacky...
 func (f *TestingClientFactory) NewWait(wait *sync.WaitGroup) *api.Client {
-	client := api.NewClient(f.host, f.port, [16]byte(getNextId()))
+	client := f.NewClient()
 
     id := client.Id()
 	f.logger.Info("factory new client with wait", "id", id)
@@ -201,5 +231,5 @@ func (f *TestingClientFactory) NewWait(wait *sync.WaitGroup) *api.Client {
 		client.WaitForReady()
 	}()
 
-	return &client
+	return client
 }
@@ -6,12 +6,14 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"time"
 
 	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
 	"vim-arcade.theprimeagen.com/pkg/api"
 	"vim-arcade.theprimeagen.com/pkg/assert"
 	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
 	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+	tlsconfig "vim-arcade.theprimeagen.com/pkg/tls-config"
 )
 
 type ServerCreationConfig struct {
@@ -21,7 +23,7 @@ type ServerCreationConfig struct {
 
 func createServer(ctx context.Context, server *ServerState, logger *slog.Logger) (string, *gameserverstats.GameServerConfig) {
     logger.Info("creating server")
-    sId, err := server.Server.CreateNewServer(ctx)
+    sId, err := server.Server.CreateNewServer(ctx, gameserverstats.DefaultGame)
     logger.Info("created server", "id", sId, "err", err)
     assert.NoError(err, "unable to create server")
     logger.Info("waiting server...", "id", sId)
@@ -116,22 +118,78 @@ func CreateEnvironment(ctx context.Context, path string, params servermanagement
     sqlite := gameserverstats.NewSqlite(gameserverstats.EnsureSqliteURI(path))
     logger.Info("creating local servers", "params", params)
     local := servermanagement.NewLocalServers(sqlite, params)
+    go local.Run(ctx)
     logger.Info("creating matchmaking", "port", port)
 
-    proxy := amproxy.NewAMProxy(ctx, &local, amproxy.CreateTCPConnectionFrom)
+    proxyConfig := amproxy.AMProxyConfigFromEnv()
+    auth, err := proxyConfig.Authenticator()
+    assert.NoError(err, "unable to create authenticator")
+
+    gameServers, err := proxyConfig.GameServerConnections()
+    assert.NoError(err, "unable to create game server tls")
+    listenerTLS, err := proxyConfig.ListenerTLS()
+    assert.NoError(err, "unable to create listener tls")
+
+    proxy := amproxy.NewAMProxy(ctx, &local, gameServers, auth)
+    proxy.WithGameServerIntegrity([]byte(proxyConfig.GameServerSecret), proxyConfig.PacketChecksums)
+    proxy.WithHeartbeat(proxyConfig.Heartbeat())
+    proxy.WithAuthTimeout(time.Duration(proxyConfig.AuthTimeoutMS) * time.Millisecond)
+    proxy.WithConnectionLimits(proxyConfig.Limits())
+    proxy.WithMatchmakingQueue(proxyConfig.Queue())
+    proxy.WithPartyTimeout(time.Duration(proxyConfig.PartyTimeoutMS) * time.Millisecond)
+    proxy.WithResumeGracePeriod(time.Duration(proxyConfig.ResumeGraceMS) * time.Millisecond)
     tcpProxy := amproxy.NewTCPProxy(&proxy, uint16(port))
+    tcpProxy.WithTLS(listenerTLS)
     go tcpProxy.Run(ctx)
     tcpProxy.WaitForReady(ctx)
 
+    if proxyConfig.WebSocketPort > 0 {
+        wsProxy := amproxy.NewWebSocketProxy(&proxy, uint16(proxyConfig.WebSocketPort))
+        go func() {
+            assert.NoError(wsProxy.Run(ctx), "websocket proxy failed")
+        }()
+        wsProxy.WaitForReady(ctx)
+    }
+
+    if proxyConfig.MetricsPort > 0 {
+        metrics := amproxy.NewMetricsServer(&proxy, sqlite, uint16(proxyConfig.MetricsPort))
+        go func() {
+            assert.NoError(metrics.Run(ctx), "metrics server failed")
+        }()
+        metrics.WaitForReady(ctx)
+    }
+
+    if proxyConfig.AdminPort > 0 && proxyConfig.AdminToken != "" {
+        admin := amproxy.NewAdminServer(&proxy, sqlite, proxyConfig.AdminToken, uint16(proxyConfig.AdminPort))
+        go func() {
+            assert.NoError(admin.Run(ctx), "admin server failed")
+        }()
+        admin.WaitForReady(ctx)
+    }
+
     logger.Info("creating client factory", "port", port)
     factory := NewTestingClientFactory("0.0.0.0", uint16(port), logger)
+    if proxyConfig.AuthSecret != "" {
+        factory.WithTokens(func(id []byte) []byte {
+            return amproxy.CreateHMACToken([]byte(proxyConfig.AuthSecret), id, time.Now().Add(time.Hour))
+        })
+    }
+
+    // the sim's certs are self signed, see tlsconfig.WriteSelfSigned
+    if listenerTLS != nil {
+        clientTLS, err := tlsconfig.ClientConfig("", "", proxyConfig.TLSCertFile)
+        assert.NoError(err, "unable to create client tls")
+        factory.WithTLS(clientTLS)
+    }
 
     logger.Info("creating server state object", "port", port)
     server := ServerState{
         Sqlite: sqlite,
         Server: &local,
         Proxy: &tcpProxy,
+        AMProxy: &proxy,
         Port: port,
+        WebSocketPort: proxyConfig.WebSocketPort,
         Factory: &factory,
         Conns: nil,
     }
@@ -17,9 +17,13 @@ type ServerState struct {
 	Sqlite  *gameserverstats.Sqlite
 	Server  *servermanagement.LocalServers
 	Proxy   *amproxy.AMTCPProxy
+	AMProxy *amproxy.AMProxy
 	Port    int
 	Factory *TestingClientFactory
 	Conns   ConnMap
+
+	// 0 without WEBSOCKET_PORT
+	WebSocketPort int
 }
 
 func (s *ServerState) Close() {
@@ -9,9 +9,15 @@ import (
 	prettylog "vim-arcade.theprimeagen.com/pkg/pretty-log"
 )
 
-func KillContext(cancel context.CancelFunc) {
+// KillContext blows up when ctx is still going after 5 seconds, a test that
+// already cancelled ctx is done and left alone
+func KillContext(ctx context.Context, cancel context.CancelFunc) {
     go func() {
-        time.Sleep(time.Second * 5)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(time.Second * 5):
+        }
         cancel()
         assert.Never("context should never be killed with KillContext")
     }()
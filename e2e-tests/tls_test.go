@@ -0,0 +1,115 @@
+package e2etests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+	tlsconfig "vim-arcade.theprimeagen.com/pkg/tls-config"
+)
+
+type testCert struct {
+    cert string
+    key string
+}
+
+// selfSigned is good for every address the sim dials, game servers say they
+// are on 0.0.0.0
+func selfSigned(t *testing.T, name string) testCert {
+    dir := t.TempDir()
+    c := testCert{
+        cert: filepath.Join(dir, name + ".pem"),
+        key: filepath.Join(dir, name + "-key.pem"),
+    }
+    require.NoError(t, tlsconfig.WriteSelfSigned(c.cert, c.key, "127.0.0.1", "0.0.0.0", "localhost"))
+    return c
+}
+
+// rotate writes over the cert in place, the way a renewal would
+func rotate(t *testing.T, from testCert, to testCert) {
+    for src, dst := range map[string]string{from.cert: to.cert, from.key: to.key} {
+        data, err := os.ReadFile(src)
+        require.NoError(t, err)
+        require.NoError(t, os.WriteFile(dst, data, 0600))
+
+        later := time.Now().Add(time.Second)
+        require.NoError(t, os.Chtimes(dst, later, later))
+    }
+}
+
+func tlsEnvironment(t *testing.T, ctx context.Context, proxy testCert, gameServer testCert) sim.ServerState {
+    t.Setenv("TLS_CERT_FILE", proxy.cert)
+    t.Setenv("TLS_KEY_FILE", proxy.key)
+
+    // the proxy and the game servers share the cert, it is its own ca
+    t.Setenv("GAME_SERVER_TLS_CERT_FILE", gameServer.cert)
+    t.Setenv("GAME_SERVER_TLS_KEY_FILE", gameServer.key)
+    t.Setenv("GAME_SERVER_TLS_CA_FILE", gameServer.cert)
+
+    path := sim.GetDBPath("no_server")
+    return sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+}
+
+func TestTLSToProxyAndGameServers(t *testing.T) {
+    sim.CreateLogger("TestTLSToProxyAndGameServers")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    proxyCert := selfSigned(t, "proxy")
+    state := tlsEnvironment(t, ctx, proxyCert, selfSigned(t, "game-server"))
+    t.Cleanup(func() {cancel()})
+
+    client := state.Factory.New()
+    require.NotEmpty(t, client.ServerId)
+    waitForServerConnections(t, &state, client.ServerId, 1)
+
+    // cleartext is not spoken here
+    plain := state.Factory.NewClient()
+    plain.WithTLS(nil)
+    require.Error(t, plain.Connect(ctx))
+
+    // renewed while running, new connections see the new cert
+    renewed := selfSigned(t, "renewed")
+    rotate(t, renewed, proxyCert)
+
+    stale := state.Factory.NewClient()
+    require.ErrorContains(t, stale.Connect(ctx), "certificate")
+
+    renewedTLS, err := tlsconfig.ClientConfig("", "", renewed.cert)
+    require.NoError(t, err)
+    fresh := state.Factory.NewClient()
+    fresh.WithTLS(renewedTLS)
+    require.NoError(t, fresh.Connect(ctx))
+    require.Equal(t, client.ServerId, fresh.ServerId)
+    waitForServerConnections(t, &state, client.ServerId, 2)
+
+    // the rotation did not touch anyone already connected
+    sim.AssertConnectionsOnProxy(&state, 2)
+}
+
+func TestTLSRefusesUntrustedGameServers(t *testing.T) {
+    sim.CreateLogger("TestTLSRefusesUntrustedGameServers")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    state := tlsEnvironment(t, ctx, selfSigned(t, "proxy"), selfSigned(t, "game-server"))
+    t.Cleanup(func() {cancel()})
+
+    // game servers launched from here on present a cert the proxy's ca
+    // never signed
+    stranger := selfSigned(t, "stranger")
+    t.Setenv("GAME_SERVER_TLS_CERT_FILE", stranger.cert)
+    t.Setenv("GAME_SERVER_TLS_KEY_FILE", stranger.key)
+
+    client := state.Factory.NewClient()
+    require.ErrorContains(t, client.Connect(ctx), "certificate")
+    require.Equal(t, 1, state.AMProxy.Stats().MatchmakingFailures)
+    sim.AssertConnectionsOnProxy(&state, 0)
+}
@@ -0,0 +1,97 @@
+package e2etests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/e2e-tests/sim"
+	"vim-arcade.theprimeagen.com/pkg/api"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+func webSocketEnvironment(t *testing.T, ctx context.Context) sim.ServerState {
+    port, err := api.GetFreePort()
+    require.NoError(t, err)
+    t.Setenv("WEBSOCKET_PORT", fmt.Sprintf("%d", port))
+
+    path := sim.GetDBPath("no_server")
+    return sim.CreateEnvironment(ctx, path, servermanagement.ServerParams{
+        MaxLoad: 0.9,
+    })
+}
+
+func webSocketClient(state *sim.ServerState) *api.Client {
+    client := state.Factory.NewClient()
+    client.Port = uint16(state.WebSocketPort)
+    client.WithWebSocket("/")
+    return client
+}
+
+func TestWebSocketClientPlaysWithTCPClients(t *testing.T) {
+    sim.CreateLogger("TestWebSocketClientPlaysWithTCPClients")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("HEARTBEAT_INTERVAL_MS", "50")
+    state := webSocketEnvironment(t, ctx)
+    t.Cleanup(func() {cancel()})
+
+    tcp := state.Factory.New()
+
+    browser := webSocketClient(&state)
+    browser.WithHeartbeat(packet.HeartbeatConfig{
+        Interval: time.Millisecond * 50,
+        MaxMissed: 3,
+    })
+    require.NoError(t, browser.Connect(ctx))
+
+    // one proxy, one game server for both
+    require.Equal(t, tcp.ServerId, browser.ServerId)
+    waitForServerConnections(t, &state, browser.ServerId, 2)
+    sim.AssertConnectionsOnProxy(&state, 2)
+
+    conns := state.AMProxy.Connections()
+    require.Equal(t, browser.Id(), conns[1].ClientId)
+
+    // pings both ways make it through the websocket
+    require.Eventually(t, func() bool {
+        return browser.RTT() > 0
+    }, time.Second, 10 * time.Millisecond)
+    require.Equal(t, 0, state.AMProxy.Stats().HeartbeatTimeouts)
+
+    browser.Disconnect()
+    browser.WaitForDone()
+    waitForServerConnections(t, &state, tcp.ServerId, 1)
+    sim.AssertConnectionsOnProxy(&state, 1)
+}
+
+func TestWebSocketAuthAndUpgradeFailures(t *testing.T) {
+    sim.CreateLogger("TestWebSocketAuthAndUpgradeFailures")
+    ctx, cancel := context.WithCancel(context.Background())
+    sim.KillContext(ctx, cancel)
+
+    t.Setenv("AUTH_SECRET", "hunter2")
+    state := webSocketEnvironment(t, ctx)
+    t.Cleanup(func() {cancel()})
+
+    // a plain request is not an upgrade
+    rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", state.WebSocketPort))
+    require.NoError(t, err)
+    rsp.Body.Close()
+    require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+    // the Authenticator is the same for every front end
+    client := webSocketClient(&state)
+    client.WithToken(nil)
+    require.ErrorIs(t, client.Connect(ctx), api.ClientAuthRejected)
+    require.Equal(t, 1, state.AMProxy.Stats().AuthFailures)
+
+    client = webSocketClient(&state)
+    require.NoError(t, client.Connect(ctx))
+    waitForServerConnections(t, &state, client.ServerId, 1)
+}
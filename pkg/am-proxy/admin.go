@@ -0,0 +1,212 @@
+package amproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"vim-arcade.theprimeagen.com/pkg/assert"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+type AdminServerInfo struct {
+	gameserverstats.GameServerConfig
+	StateName string `json:"stateName"`
+	Draining  bool   `json:"draining"`
+}
+
+// AMAdminServer is a JSON api over the proxy and its game servers.  Every
+// request needs the bearer token
+//
+//	GET    /servers               game servers and if they are draining
+//	POST   /servers               create a game server, does not wait for ready,
+//	                              429 when the server limits say no
+//	POST   /servers/{id}/drain    stop matching connections to the server
+//	DELETE /servers/{id}/drain    start matching connections again
+//	GET    /connections           the proxy's connections
+//	DELETE /connections/{id}      close a connection
+type AMAdminServer struct {
+	proxy  *AMProxy
+	stats  gameserverstats.GSSRetriever
+	token  []byte
+	port   uint16
+	logger *slog.Logger
+	ready  chan struct{}
+
+	// servers created through the api live as long as Run's context
+	ctx context.Context
+}
+
+func NewAdminServer(proxy *AMProxy, stats gameserverstats.GSSRetriever, token string, port uint16) AMAdminServer {
+	assert.Assert(token != "", "the admin server cannot run without a token")
+	return AMAdminServer{
+		proxy:  proxy,
+		stats:  stats,
+		token:  []byte(token),
+		port:   port,
+		logger: slog.Default().With("area", "AMAdminServer"),
+		ready:  make(chan struct{}, 1),
+		ctx:    context.Background(),
+	}
+}
+
+func (a *AMAdminServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /servers", a.listServers)
+	mux.HandleFunc("POST /servers", a.createServer)
+	mux.HandleFunc("POST /servers/{id}/drain", a.drainServer(true))
+	mux.HandleFunc("DELETE /servers/{id}/drain", a.drainServer(false))
+	mux.HandleFunc("GET /connections", a.listConnections)
+	mux.HandleFunc("DELETE /connections/{id}", a.closeConnection)
+	return a.authorize(mux)
+}
+
+func (a *AMAdminServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), a.token) != 1 {
+			a.logger.Warn("unauthorized request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			a.writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AMAdminServer) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Error("unable to write response", "error", err)
+	}
+}
+
+func (a *AMAdminServer) writeError(w http.ResponseWriter, status int, err error) {
+	a.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (a *AMAdminServer) listServers(w http.ResponseWriter, r *http.Request) {
+	configs, err := a.stats.GetAllGameServerConfigs()
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	servers := make([]AdminServerInfo, 0, len(configs))
+	for _, c := range configs {
+		servers = append(servers, AdminServerInfo{
+			GameServerConfig: c,
+			StateName:        gameserverstats.StateToString(c.State),
+			Draining:         a.proxy.servers.IsDraining(c.Id),
+		})
+	}
+
+	a.writeJSON(w, http.StatusOK, servers)
+}
+
+// createServer takes the game as ?game=name@version, the default game without
+func (a *AMAdminServer) createServer(w http.ResponseWriter, r *http.Request) {
+	game := gameserverstats.DefaultGame
+	if param := r.URL.Query().Get("game"); param != "" {
+		parsed, err := gameserverstats.ParseGameType(param)
+		if err != nil {
+			a.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		game = parsed
+	}
+
+	id, err := a.proxy.servers.CreateNewServer(a.ctx, game)
+
+	var limit *servermanagement.LimitError
+	if errors.As(err, &limit) {
+		if limit.RetryAfter > 0 {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(limit.RetryAfter.Seconds())+1))
+		}
+		a.writeError(w, http.StatusTooManyRequests, err)
+		return
+	} else if errors.Is(err, servermanagement.UnknownGameType) {
+		a.writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	a.logger.Warn("created server", "id", id, "game", game.String())
+	a.writeJSON(w, http.StatusCreated, map[string]string{"id": id, "game": game.String()})
+}
+
+func (a *AMAdminServer) drainServer(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		err := a.proxy.servers.SetDraining(id, draining)
+		if errors.Is(err, servermanagement.UnknownServer) {
+			a.writeError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.writeJSON(w, http.StatusOK, map[string]any{"id": id, "draining": draining})
+	}
+}
+
+func (a *AMAdminServer) listConnections(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, a.proxy.Connections())
+}
+
+func (a *AMAdminServer) closeConnection(w http.ResponseWriter, r *http.Request) {
+	err := a.proxy.CloseConnection(r.PathValue("id"))
+	if errors.Is(err, AMProxyConnectionNotFound) {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AMAdminServer) WaitForReady(ctx context.Context) {
+	select {
+	case <-a.ready:
+	case <-ctx.Done():
+	}
+}
+
+func (a *AMAdminServer) Run(ctx context.Context) error {
+	a.ctx = ctx
+
+	addr := fmt.Sprintf("0.0.0.0:%d", a.port)
+	listener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: a.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	a.logger.Info("admin listening", "host:port", addr)
+	a.ready <- struct{}{}
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -1,14 +1,137 @@
 package amproxy
 
 import (
+	"crypto/tls"
 	"os"
+	"slices"
 	"strconv"
+	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	tlsconfig "vim-arcade.theprimeagen.com/pkg/tls-config"
 )
 
 type AMProxyConfig struct {
     AuthTimeoutMS int64 `json:"authTimeoutMS"`
+
+    // at most one of these, neither means every client is let in
+    AuthSecret string `json:"-"`
+    AuthKeyFile string `json:"authKeyFile"`
+
+    // proxy <-> game server frame integrity, see packet.Integrity
+    PacketChecksums bool `json:"packetChecksums"`
+    GameServerSecret string `json:"-"`
+
+    // the client listener is plain tcp without both, the cert is reloaded
+    // when either file changes
+    TLSCertFile string `json:"tlsCertFile"`
+    TLSKeyFile string `json:"tlsKeyFile"`
+
+    // mutual tls to game servers, all three or none.  Game servers are
+    // given the same env and have to present a cert signed by the ca
+    GameServerTLSCertFile string `json:"gameServerTLSCertFile"`
+    GameServerTLSKeyFile string `json:"gameServerTLSKeyFile"`
+    GameServerTLSCAFile string `json:"gameServerTLSCAFile"`
+
+    // 0 disables heartbeats
+    HeartbeatIntervalMS int64 `json:"heartbeatIntervalMS"`
+    HeartbeatMaxMissed int `json:"heartbeatMaxMissed"`
+
+    // 0 is unlimited for all of these, see ConnectionLimits
+    ConnectionRatePerIP float64 `json:"connectionRatePerIP"`
+    ConnectionBurstPerIP int `json:"connectionBurstPerIP"`
+    MaxConnectionsPerIP int `json:"maxConnectionsPerIP"`
+    MaxConnections int `json:"maxConnections"`
+
+    // 0 does not serve metrics
+    MetricsPort int `json:"metricsPort"`
+
+    // 0 does not accept websockets, see AMWebSocketProxy
+    WebSocketPort int `json:"webSocketPort"`
+
+    // how long a drain waits for connections to close before cutting them off
+    DrainTimeoutMS int64 `json:"drainTimeoutMS"`
+
+    // the admin api only runs with both
+    AdminPort int `json:"adminPort"`
+    AdminToken string `json:"-"`
+
+    // connections wait here once no more game servers can be created, see
+    // QueueConfig
+    MatchmakingQueueSize int `json:"matchmakingQueueSize"`
+    MatchmakingQueueTimeoutMS int64 `json:"matchmakingQueueTimeoutMS"`
+
+    // how long a party waits for all of its members
+    PartyTimeoutMS int64 `json:"partyTimeoutMS"`
+
+    // how long a dropped client has to resume onto its game server, 0 never
+    // resumes
+    ResumeGraceMS int64 `json:"resumeGraceMS"`
+}
+
+func (a *AMProxyConfig) Limits() ConnectionLimits {
+    return ConnectionLimits{
+        RatePerSecond: a.ConnectionRatePerIP,
+        Burst: a.ConnectionBurstPerIP,
+        MaxPerIP: a.MaxConnectionsPerIP,
+        MaxTotal: a.MaxConnections,
+    }
+}
+
+func (a *AMProxyConfig) Queue() QueueConfig {
+    return QueueConfig{
+        Size: a.MatchmakingQueueSize,
+        Timeout: time.Duration(a.MatchmakingQueueTimeoutMS) * time.Millisecond,
+    }
+}
+
+func (a *AMProxyConfig) Heartbeat() packet.HeartbeatConfig {
+    return packet.HeartbeatConfig{
+        Interval: time.Duration(a.HeartbeatIntervalMS) * time.Millisecond,
+        MaxMissed: a.HeartbeatMaxMissed,
+    }
+}
+
+// Authenticator is nil when no authentication is configured
+func (a *AMProxyConfig) Authenticator() (Authenticator, error) {
+    assert.Assert(a.AuthSecret == "" || a.AuthKeyFile == "", "only one of AUTH_SECRET and AUTH_KEY_FILE can be provided")
+
+    if a.AuthSecret != "" {
+        return NewHMACTokenAuthenticator([]byte(a.AuthSecret)), nil
+    }
+
+    if a.AuthKeyFile != "" {
+        return NewJWTAuthenticatorFromFile(a.AuthKeyFile)
+    }
+
+    return nil, nil
+}
+
+// ListenerTLS is nil when the client listener is plain tcp
+func (a *AMProxyConfig) ListenerTLS() (*tls.Config, error) {
+    assert.Assert((a.TLSCertFile == "") == (a.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE are provided together")
+
+    if a.TLSCertFile == "" {
+        return nil, nil
+    }
+    return tlsconfig.ServerConfig(a.TLSCertFile, a.TLSKeyFile, "")
+}
+
+// GameServerConnections dials game servers over mutual tls when configured
+func (a *AMProxyConfig) GameServerConnections() (ConnectionFactory, error) {
+    files := []string{a.GameServerTLSCertFile, a.GameServerTLSKeyFile, a.GameServerTLSCAFile}
+    assert.Assert(!slices.Contains(files, "") || slices.Equal(files, []string{"", "", ""}), "GAME_SERVER_TLS_CERT_FILE, GAME_SERVER_TLS_KEY_FILE and GAME_SERVER_TLS_CA_FILE are provided together")
+
+    if a.GameServerTLSCertFile == "" {
+        return CreateTCPConnectionFrom, nil
+    }
+
+    config, err := tlsconfig.ClientConfig(a.GameServerTLSCertFile, a.GameServerTLSKeyFile, a.GameServerTLSCAFile)
+    if err != nil {
+        return nil, err
+    }
+    return CreateTLSConnectionFactory(config), nil
 }
 
 func readInt(key string, d int) int {
@@ -23,9 +146,45 @@ func readInt(key string, d int) int {
     return v
 }
 
+func readFloat(key string, d float64) float64 {
+    vStr := os.Getenv(key)
+    v, err := strconv.ParseFloat(vStr, 64)
+    assert.Assert(err == nil || err != nil && len(vStr) == 0, "environment provided an invalid float")
+
+    if err != nil {
+        return d
+    }
+
+    return v
+}
+
 func AMProxyConfigFromEnv() AMProxyConfig {
     return AMProxyConfig{
         AuthTimeoutMS: int64(readInt("AUTH_TIMEOUT_MS", 5000)),
+        AuthSecret: os.Getenv("AUTH_SECRET"),
+        AuthKeyFile: os.Getenv("AUTH_KEY_FILE"),
+        PacketChecksums: readInt("PACKET_CHECKSUMS", 0) > 0,
+        GameServerSecret: os.Getenv("GAME_SERVER_SECRET"),
+        TLSCertFile: os.Getenv("TLS_CERT_FILE"),
+        TLSKeyFile: os.Getenv("TLS_KEY_FILE"),
+        GameServerTLSCertFile: os.Getenv("GAME_SERVER_TLS_CERT_FILE"),
+        GameServerTLSKeyFile: os.Getenv("GAME_SERVER_TLS_KEY_FILE"),
+        GameServerTLSCAFile: os.Getenv("GAME_SERVER_TLS_CA_FILE"),
+        HeartbeatIntervalMS: int64(readInt("HEARTBEAT_INTERVAL_MS", 5000)),
+        HeartbeatMaxMissed: readInt("HEARTBEAT_MAX_MISSED", 3),
+        ConnectionRatePerIP: readFloat("CONNECTION_RATE_PER_IP", 0),
+        ConnectionBurstPerIP: readInt("CONNECTION_BURST_PER_IP", 0),
+        MaxConnectionsPerIP: readInt("MAX_CONNECTIONS_PER_IP", 0),
+        MaxConnections: readInt("MAX_CONNECTIONS", 0),
+        MetricsPort: readInt("METRICS_PORT", 0),
+        WebSocketPort: readInt("WEBSOCKET_PORT", 0),
+        DrainTimeoutMS: int64(readInt("DRAIN_TIMEOUT_MS", 10000)),
+        AdminPort: readInt("ADMIN_PORT", 0),
+        AdminToken: os.Getenv("ADMIN_TOKEN"),
+        MatchmakingQueueSize: readInt("MATCHMAKING_QUEUE_SIZE", 0),
+        MatchmakingQueueTimeoutMS: int64(readInt("MATCHMAKING_QUEUE_TIMEOUT_MS", 60000)),
+        PartyTimeoutMS: int64(readInt("PARTY_TIMEOUT_MS", int(DEFAULT_PARTY_TIMEOUT.Milliseconds()))),
+        ResumeGraceMS: int64(readInt("RESUME_GRACE_MS", 0)),
     }
 }
 
@@ -1,90 +1,315 @@
 package amproxy
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
 	"vim-arcade.theprimeagen.com/pkg/packet"
 )
 
 var AMProxyDisallowed = fmt.Errorf("unable to connnect, please try again later")
+var AMProxyGameServerClosed = fmt.Errorf("game server connection closed unexpectedly")
+var AMProxyAuthTimeout = fmt.Errorf("authentication timed out")
+var AMProxyConnectionNotFound = fmt.Errorf("connection not found")
+var AMProxyConnectionKicked = fmt.Errorf("connection closed by an administrator")
+var AMProxyDraining = fmt.Errorf("server is shutting down, please try again later")
 
 type AMConnectionWrapper struct {
-	cConn AMConnection
-	gConn AMConnection
+	// the client connection's id, see AMConnection.Id
+	id string
+
+	// every line about the connection carries its ids, the client's once it
+	// has authenticated
+	logger *slog.Logger
+
+	// the client's id from its auth packet
+	clientId []byte
+
+	// cConn is replaced when a parked connection resumes, anything other than
+	// the connection's own goroutines reads it through client()
+	cConnM sync.Mutex
+	cConn  AMConnection
+	gConn  AMConnection
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	cFramer packet.PacketFramer
+	cFramer *packet.PacketFramer
 	gFramer packet.PacketFramer
 
+	// nil when the game server hop has no integrity
+	gIntegrity *packet.Integrity
+
+	cHeartbeat *packet.Heartbeat
+	gHeartbeat *packet.Heartbeat
+
+	// what the client asked to play, set once authenticated
+	game gameserverstats.GameType
+
 	// hell yeah brother
+	// set once matched, under the proxy's connsM
 	gsId string
+
+	added time.Time
+
+	// the address whose limiter slot is given back on close.  Under the
+	// proxy's connsM, a resume hands it to the wrapper the client came from
+	slotAddr string
+
+	// resumeToken and parked are under the proxy's connsM, see resume.go
+	resumeToken string
+	parked      bool
+	resumed     chan resumedClient
+
+	// removing a connection can happen from several places at once
+	closeOnce sync.Once
+	onClose   func()
 }
 
-func (a *AMConnectionWrapper) Close() error {
-	a.cancel()
-	a.cConn.Close()
-	if a.gConn != nil {
-		a.gConn.Close()
-	}
-	return nil
+// bindClient ties the authenticated client's id to the connection, before
+// the connection is matched
+func (a *AMConnectionWrapper) bindClient(id []byte) {
+	a.clientId = bytes.Clone(id)
+	clientId := hex.EncodeToString(id)
+	a.cConn.SetClientId(clientId)
+	a.logger = a.logger.With("client", clientId)
 }
 
-type AMProxyStats struct {
-	ActiveConnections int
-	TotalConnections  int
-	Errors            int
+func (a *AMConnectionWrapper) client() AMConnection {
+	a.cConnM.Lock()
+	defer a.cConnM.Unlock()
+	return a.cConn
+}
+
+func (a *AMConnectionWrapper) setClient(conn AMConnection) {
+	a.cConnM.Lock()
+	defer a.cConnM.Unlock()
+	a.cConn = conn
+}
+
+func (a *AMConnectionWrapper) Close() error {
+	a.closeOnce.Do(func() {
+		a.cancel()
+		if conn := a.client(); conn != nil {
+			conn.Close()
+		}
+		if a.gConn != nil {
+			a.gConn.Close()
+		}
+		if a.onClose != nil {
+			a.onClose()
+		}
+	})
+	return nil
 }
 
 type AMProxy struct {
 	servers GameServer
 	match   *MatchMakingServer
 	factory ConnectionFactory
+	auth    Authenticator
 
 	logger *slog.Logger
 	ctx    context.Context
 	cancel context.CancelFunc
 	closed bool
-	stats  AMProxyStats
+	stats  proxyStats
+
+	connsM sync.Mutex
+	conns  map[string]*AMConnectionWrapper
+
+	// connections without a client, by resume token.  0 grace never parks
+	parked      map[string]*AMConnectionWrapper
+	resumeGrace time.Duration
+
+	// closed once Drain is called, every connection listens on it
+	draining  chan struct{}
+	drainOnce sync.Once
+
+	// 0 waits forever for the client auth packet
+	authTimeout time.Duration
+	limiter     *connectionLimiter
+
+	authLatency      *latencyHistogram
+	matchmakeLatency *latencyHistogram
+
+	integritySecret []byte
+	checksums       bool
+	heartbeat       packet.HeartbeatConfig
 }
 
-func NewAMProxy(outer context.Context, servers GameServer, factory ConnectionFactory) AMProxy {
+// NewAMProxy with a nil Authenticator lets every client in
+func NewAMProxy(outer context.Context, servers GameServer, factory ConnectionFactory, auth Authenticator) AMProxy {
 	ctx, cancel := context.WithCancel(outer)
 	return AMProxy{
 		servers: servers,
 		match:   NewMatchMakingServer(servers),
 		factory: factory,
+		auth:    auth,
 
 		logger: slog.Default().With("area", "AMProxy"),
 		ctx:    ctx,
 		cancel: cancel,
 		closed: false,
-		stats:  AMProxyStats{},
+		conns:  map[string]*AMConnectionWrapper{},
+		parked: map[string]*AMConnectionWrapper{},
+
+		draining: make(chan struct{}),
+
+		limiter: newConnectionLimiter(ConnectionLimits{}),
+
+		authLatency:      newLatencyHistogram(),
+		matchmakeLatency: newLatencyHistogram(),
 	}
 }
 
-func (m *AMProxy) allowedToConnect(AMConnection) error {
-	return nil
+// WithGameServerIntegrity adds a trailer to every frame on the proxy to game
+// server hop.  Frames are signed with a key derived per game server from the
+// secret, an empty secret means no signing
+func (m *AMProxy) WithGameServerIntegrity(secret []byte, checksums bool) *AMProxy {
+	m.integritySecret = secret
+	m.checksums = checksums
+	return m
+}
+
+// WithHeartbeat pings both the client and the game server of every proxied
+// connection and drops the connection when either misses too many pongs
+func (m *AMProxy) WithHeartbeat(config packet.HeartbeatConfig) *AMProxy {
+	m.heartbeat = config
+	return m
+}
+
+// WithAuthTimeout closes connections that haven't sent a PacketClientAuth
+// within the timeout
+func (m *AMProxy) WithAuthTimeout(timeout time.Duration) *AMProxy {
+	m.authTimeout = timeout
+	return m
+}
+
+// WithConnectionLimits replaces the limits, connections already let in are
+// forgotten by the new limits
+func (m *AMProxy) WithConnectionLimits(limits ConnectionLimits) *AMProxy {
+	m.limiter = newConnectionLimiter(limits)
+	return m
+}
+
+// WithMatchmakingQueue queues connections once no more game servers can be
+// created instead of failing them
+func (m *AMProxy) WithMatchmakingQueue(config QueueConfig) *AMProxy {
+	m.match.queue = newMatchQueue(config)
+	return m
+}
+
+// WithPartyTimeout is how long a party waits for all of its members after the
+// first one shows up
+func (m *AMProxy) WithPartyTimeout(timeout time.Duration) *AMProxy {
+	m.match.parties = newPartyHolder(timeout)
+	return m
+}
+
+// Stats is safe to call at any point from any goroutine
+func (m *AMProxy) Stats() AMProxyStats {
+	stats := m.stats.snapshot()
+	stats.Limits = m.limiter.limits
+	stats.TrackedIPs = m.limiter.trackedIPs()
+	stats.QueuedConnections = m.match.QueueLength()
+	stats.PartiesWaiting = m.match.PartiesWaiting()
+	stats.ParkedConnections = m.parkedConnections()
+	return stats
+}
+
+func (m *AMProxy) gameServerIntegrity(gsId string) *packet.Integrity {
+	integrity := &packet.Integrity{Checksum: m.checksums}
+	if len(m.integritySecret) > 0 {
+		integrity.Key = packet.DeriveKey(m.integritySecret, gsId)
+	}
+
+	if !integrity.Enabled() {
+		return nil
+	}
+	return integrity
+}
+
+// allowedToConnect reserves room for the connection, which is given back by
+// closing the connection's wrapper
+func (m *AMProxy) allowedToConnect(conn AMConnection) error {
+	err := m.limiter.acquire(conn.Addr())
+	if err == nil {
+		return nil
+	}
+
+	m.logger.Warn("connection disallowed", "conn", conn.Id(), "addr", conn.Addr(), "reason", err)
+
+	switch err {
+	case AMProxyRateLimited:
+		m.stats.rateLimited.Add(1)
+	case AMProxyAddressLimited:
+		m.stats.addressLimited.Add(1)
+	case AMProxyAtCapacity:
+		m.stats.capacityLimited.Add(1)
+	}
+
+	// the client doesn't get to know which limit it hit
+	return AMProxyDisallowed
 }
 
 func (m *AMProxy) Add(conn AMConnection) error {
-	assert.Assert(m.closed == false, "adding connections when the proxy has been closed")
+	// a drained proxy is closed, but connections accepted during the drain
+	// still get an answer
+	if m.IsDraining() {
+		return AMProxyDraining
+	}
 
-	m.stats.ActiveConnections += 1
+	assert.Assert(m.closed == false, "adding connections when the proxy has been closed")
 
 	if err := m.allowedToConnect(conn); err != nil {
 		return err
 	}
 
+	m.stats.activeConnections.Add(1)
+	m.stats.totalConnections.Add(1)
+
 	ctx, cancel := context.WithCancel(m.ctx)
 	wrapper := &AMConnectionWrapper{
-		cConn:  conn,
-		ctx:    ctx,
-		cancel: cancel,
+		id:      conn.Id(),
+		logger:  m.logger.With("conn", conn.Id()),
+		cConn:   conn,
+		ctx:     ctx,
+		cancel:  cancel,
+		added:   time.Now(),
+		resumed: make(chan resumedClient),
+
+		slotAddr: conn.Addr(),
+	}
+
+	m.connsM.Lock()
+	_, taken := m.conns[wrapper.id]
+	assert.Assert(!taken, "connection ids must be unique", "id", wrapper.id)
+	m.conns[wrapper.id] = wrapper
+	m.connsM.Unlock()
+
+	limiter := m.limiter
+	wrapper.onClose = func() {
+		m.stats.activeConnections.Add(-1)
+
+		m.connsM.Lock()
+		delete(m.conns, wrapper.id)
+		if m.parked[wrapper.resumeToken] == wrapper {
+			delete(m.parked, wrapper.resumeToken)
+		}
+		slotAddr := wrapper.slotAddr
+		m.connsM.Unlock()
+
+		limiter.release(slotAddr)
 	}
 
 	go m.handleConnection(wrapper)
@@ -92,102 +317,565 @@ func (m *AMProxy) Add(conn AMConnection) error {
 	return nil
 }
 
-func (m *AMProxy) authenticate(*packet.Packet) error {
+type AMConnectionInfo struct {
+	Id           string    `json:"id"`
+	ClientId     string    `json:"clientId"`
+	Addr         string    `json:"addr"`
+	GameServerId string    `json:"gameServerId"`
+	Game         string    `json:"game"`
+	Parked       bool      `json:"parked"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+}
+
+// Connections are the proxy's connections at the time of calling, including
+// the ones still authenticating or matchmaking (no GameServerId yet)
+func (m *AMProxy) Connections() []AMConnectionInfo {
+	m.connsM.Lock()
+	defer m.connsM.Unlock()
+
+	out := make([]AMConnectionInfo, 0, len(m.conns))
+	for _, w := range m.conns {
+		// a resumed client's wrapper is on its way out
+		conn := w.client()
+		if conn == nil {
+			continue
+		}
+
+		out = append(out, AMConnectionInfo{
+			Id:           w.id,
+			ClientId:     conn.ClientId(),
+			Addr:         conn.Addr(),
+			GameServerId: w.gsId,
+			Game:         game(w),
+			Parked:       w.parked,
+			ConnectedAt:  w.added,
+		})
+	}
+
+	slices.SortFunc(out, func(a, b AMConnectionInfo) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return out
+}
+
+// CloseConnection tells the client why before closing both sides
+func (m *AMProxy) CloseConnection(id string) error {
+	m.connsM.Lock()
+	w, ok := m.conns[id]
+	gsId := ""
+	if ok {
+		gsId = w.gsId
+	}
+	m.connsM.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", AMProxyConnectionNotFound, id)
+	}
+
+	w.logger.Warn("closing connection", "server-id", gsId)
+
+	// not an error as far as the stats are concerned
+	pkt := packet.CreateErrorPacket(AMProxyConnectionKicked)
+	if _, err := pkt.Into(w.client()); err != nil {
+		w.logger.Error("could not write error message into connection", "error", err)
+	}
+	w.Close()
 	return nil
 }
 
+// game is empty until the connection has authenticated
+func game(w *AMConnectionWrapper) string {
+	if w.game.Name == "" {
+		return ""
+	}
+	return w.game.String()
+}
+
+// authenticate returns what the client asked matchmaking for, the default
+// game when it did not pick one
+func (m *AMProxy) authenticate(pkt *packet.Packet) (MatchRequest, error) {
+	if pkt.Type() != packet.PacketClientAuth {
+		return MatchRequest{}, errors.Join(AMProxyAuthInvalid, fmt.Errorf("expected client auth, received %s", packet.FormatType(pkt.Type())))
+	}
+
+	auth, err := packet.ParseClientAuth(pkt)
+	if err != nil {
+		return MatchRequest{}, errors.Join(AMProxyAuthInvalid, err)
+	}
+
+	req := MatchRequest{
+		Game:      gameserverstats.GameType{Name: auth.Game, Version: auth.Version}.OrDefault(),
+		Party:     auth.Party,
+		PartySize: int(auth.PartySize),
+	}
+	if m.auth == nil {
+		return req, nil
+	}
+
+	return req, m.auth.Authenticate(auth.Id, auth.Token)
+}
+
+func (m *AMProxy) rejectConnection(w *AMConnectionWrapper, err error) {
+	w.logger.Warn("client failed authentication", "error", err)
+	m.stats.authFailures.Add(1)
+
+	resp := packet.CreateServerAuthResponse(false, authRejectReason(err))
+	if _, err := resp.Into(w.cConn); err != nil {
+		w.logger.Error("could not write auth rejection into connection", "error", err)
+	}
+
+	w.Close()
+}
+
+// refuseConnection answers the auth with a retry later instead of a game
+func (m *AMProxy) refuseConnection(w *AMConnectionWrapper) {
+	resp := packet.CreateServerAuthResponse(false, AMProxyDraining.Error())
+	if _, err := resp.Into(w.cConn); err != nil {
+		w.logger.Error("could not write auth refusal into connection", "error", err)
+	}
+
+	w.Close()
+}
+
 func (m *AMProxy) removeConnection(w *AMConnectionWrapper, report error) {
 
 	if report != nil {
+		m.stats.errors.Add(1)
 		pkt := packet.CreateErrorPacket(report)
 		_, err := pkt.Into(w.cConn)
 		if err != nil {
-			m.logger.Error("could not write error message into connection", "error", err)
+			w.logger.Error("could not write error message into connection", "error", err)
 		}
 	}
 
 	w.Close()
 }
 
+// framerError is only non nil when the peer sent garbage.  Any other reason
+// for the framer finishing means the connection is already gone and there is
+// nobody to report to
+func (m *AMProxy) framerError(w *AMConnectionWrapper, framer *packet.PacketFramer, peer string) error {
+	err := <-framer.Err
+	if err == nil {
+		return nil
+	}
+
+	if !packet.IsStreamCorrupt(err) {
+		w.logger.Info("framer finished", "peer", peer, "error", err)
+		return nil
+	}
+
+	w.logger.Error("corrupt packet stream", "peer", peer, "error", err)
+	return err
+}
+
 func (m *AMProxy) handleConnection(w *AMConnectionWrapper) {
-	w.cFramer = packet.NewPacketFramer()
+	cFramer := packet.NewPacketFramer()
+	w.cFramer = &cFramer
 	w.gFramer = packet.NewPacketFramer()
-	go packet.FrameWithReader(&w.cFramer, w.cConn)
+	go packet.FrameWithReader(w.cFramer, w.cConn)
+
+	// a nil channel never fires, so no timeout means wait forever
+	var timeout <-chan time.Time
+	if m.authTimeout > 0 {
+		timer := time.NewTimer(m.authTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 
-	// TODO(v1) this could hang forever and dumb brown hat hackers could hurt
-	// my delicious ports and memory :(
-	authPacket, ok := <-w.cFramer.C
+	var authPacket *packet.Packet
+	var ok bool
+	select {
+	case authPacket, ok = <-w.cFramer.C:
+		if !ok {
+			m.removeConnection(w, m.framerError(w, w.cFramer, "client"))
+			return
+		}
+	case <-timeout:
+		w.logger.Warn("client did not authenticate in time", "timeout", m.authTimeout)
+		m.stats.authTimeouts.Add(1)
+		m.removeConnection(w, AMProxyAuthTimeout)
+		return
+	case <-w.ctx.Done():
+		w.Close()
+		return
+	case <-m.draining:
+		m.refuseConnection(w)
+		return
+	}
 
-	if !ok || authPacket == nil {
-		m.removeConnection(w, nil)
+	if authPacket.Type() == packet.PacketClientResume {
+		err := m.resumeConnection(w, authPacket)
+		authPacket.Release()
+		m.authLatency.since(w.added)
+		if err != nil {
+			m.rejectConnection(w, err)
+		}
 		return
 	}
 
 	// TODO i probably want to have a "user" object that i can
 	// serialize/deserialize
-	if err := m.authenticate(authPacket); err != nil {
-		m.removeConnection(w, err)
+	req, err := m.authenticate(authPacket)
+	if err == nil {
+		w.bindClient(packet.ClientAuthId(authPacket))
+	}
+	authPacket.Release()
+	m.authLatency.since(w.added)
+	if err != nil {
+		m.rejectConnection(w, err)
 		return
 	}
 
+	m.connsM.Lock()
+	w.game = req.Game
+	m.connsM.Unlock()
+
 	// there is only one place to execute this...
-	gameConnInfo, err := m.match.matchmake(m.ctx, w.cConn)
+	matchmakeStart := time.Now()
+	stopWatching := m.watchWhileMatching(w)
+	gameConnInfo, err := m.match.matchmake(m.ctx, w.ctx, w.cConn, req)
+	stopWatching()
+	m.matchmakeLatency.since(matchmakeStart)
+
+	// the client left while waiting, there is nobody to tell
+	if w.ctx.Err() != nil {
+		w.Close()
+		return
+	}
+
 	if err != nil {
+		m.stats.matchmakingFailures.Add(1)
 		m.removeConnection(w, err)
 		return
 	}
 
+	// the drain started while matchmaking, nobody is going to tell this
+	// connection to close
+	if m.IsDraining() {
+		m.refuseConnection(w)
+		return
+	}
+
 	gameConn, err := m.factory(gameConnInfo.Addr)
 	if err != nil {
+		m.stats.matchmakingFailures.Add(1)
 		m.removeConnection(w, err)
 		return
 	}
 
 	w.gConn = gameConn
+	m.connsM.Lock()
+	w.gsId = gameConnInfo.Id
+	m.connsM.Unlock()
+	w.gIntegrity = m.gameServerIntegrity(w.gsId)
+	if w.gIntegrity != nil {
+		w.gFramer.SetIntegrity(*w.gIntegrity)
+	}
 	go packet.FrameWithReader(&w.gFramer, w.gConn)
 
+	if err := m.playerJoined(w, req, gameConnInfo); err != nil {
+		m.removeConnection(w, err)
+		return
+	}
+
 	// wait.. what is the id???
-	resp := packet.CreateServerAuthResponse(true, gameConnInfo.Id)
+	resp := packet.CreateServerAuthAccepted(gameConnInfo.Id, m.newResumeToken(w))
 	_, err = resp.Into(w.cConn)
 	if err != nil {
 		m.removeConnection(w, err)
 		return
 	}
 
+	w.cHeartbeat = packet.NewHeartbeat(m.heartbeat)
+	w.gHeartbeat = packet.NewHeartbeat(m.heartbeat)
 	go m.handleConnectionLifecycles(w)
 }
 
+// playerJoined tells the game server who is on the other end of the
+// connection before anything else is sent to it
+func (m *AMProxy) playerJoined(w *AMConnectionWrapper, req MatchRequest, info *GameConnectionInfo) error {
+	var key []byte
+	if len(m.integritySecret) > 0 {
+		key = packet.DeriveKey(m.integritySecret, w.gsId)
+	}
+
+	pkt, err := packet.CreatePlayerJoined(packet.PlayerJoined{
+		ClientId:  w.clientId,
+		ConnId:    w.id,
+		Addr:      w.cConn.Addr(),
+		Party:     req.Party,
+		PartySize: uint8(req.PartySize),
+		Queued:    info.Queued,
+		IssuedAt:  time.Now(),
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = pkt.IntoWithIntegrity(w.gConn, w.gIntegrity)
+	return err
+}
+
+// watchWhileMatching closes the connection when the client goes away while
+// it waits on a game server.  The client has nothing to say before it is
+// matched, anything it does send ends the connection.  The returned func
+// gives the client's framer back
+func (m *AMProxy) watchWhileMatching(w *AMConnectionWrapper) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		select {
+		case <-stop:
+		case pkt, ok := <-w.cFramer.C:
+			if ok {
+				w.logger.Warn("client sent a packet before being matched", "packet", pkt.String())
+				pkt.Release()
+			}
+			w.cancel()
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// beat is the error to drop the connection with, nil while the peer is there
+func (m *AMProxy) beat(w *AMConnectionWrapper, heartbeat *packet.Heartbeat, conn AMConnection, integrity *packet.Integrity, peer string, report error) error {
+	ping, err := heartbeat.Beat()
+	if err != nil {
+		w.logger.Warn("peer missed heartbeats, dropping connection", "peer", peer, "server-id", w.gsId, "error", err)
+		m.stats.heartbeatTimeouts.Add(1)
+		return report
+	}
+
+	_, err = ping.IntoWithIntegrity(conn, integrity)
+	return err
+}
+
+func (m *AMProxy) pong(w *AMConnectionWrapper, heartbeat *packet.Heartbeat, pkt *packet.Packet, peer string) {
+	rtt, err := heartbeat.Pong(pkt)
+	if err != nil {
+		w.logger.Error("bad pong", "peer", peer, "server-id", w.gsId, "error", err)
+		return
+	}
+
+	w.logger.Info("rtt", "peer", peer, "server-id", w.gsId, "rtt", rtt)
+}
+
 func (m *AMProxy) handleConnectionLifecycles(w *AMConnectionWrapper) {
+	defer w.Close()
+	defer func() { w.cHeartbeat.Stop() }()
+	defer w.gHeartbeat.Stop()
+
+	// both are nil while parked, there is no client to hear from
+	clientC := w.cFramer.C
+	clientBeat := w.cHeartbeat.C()
+	var parkExpired <-chan time.Time
+
+	// dropClient holds on to the game server when the client can resume,
+	// everything else is the end of the connection
+	dropClient := func(report error) {
+		if !m.park(w) {
+			m.removeConnection(w, report)
+			return
+		}
+
+		clientC = nil
+		clientBeat = nil
+		parkExpired = time.After(m.resumeGrace)
+	}
+
 	for {
+		if w.ctx.Err() != nil {
+			w.logger.Info("connection finished", "server-id", w.gsId, "client-rtt", w.cHeartbeat.RTT(), "game-rtt", w.gHeartbeat.RTT())
+			return
+		}
+
 		select {
-		case pkt := <-w.gFramer.C:
+		case pkt, ok := <-w.gFramer.C:
+			if !ok {
+				if w.ctx.Err() != nil {
+					break
+				}
+
+				if err := m.framerError(w, &w.gFramer, "game"); err != nil {
+					errPkt := packet.CreateErrorPacket(err)
+					_, _ = errPkt.IntoWithIntegrity(w.gConn, w.gIntegrity)
+				}
+				m.removeConnection(w, AMProxyGameServerClosed)
+				break
+			}
+
 			switch pkt.Type() {
+			case packet.PacketPing:
+				pong := packet.CreatePong(pkt)
+				if _, err := pong.IntoWithIntegrity(w.gConn, w.gIntegrity); err != nil {
+					m.removeConnection(w, err)
+				}
+			case packet.PacketPong:
+				m.pong(w, w.gHeartbeat, pkt, "game")
 			case packet.PacketCloseConnection:
-				_, err := pkt.Into(w.cConn)
+				if clientC == nil {
+					m.removeConnection(w, nil)
+					break
+				}
+
+				n, err := pkt.Into(w.cConn)
+				m.stats.gameToClient(n)
 				m.removeConnection(w, err)
 			default:
-				_, err := pkt.Into(w.cConn)
+				// parked, the packet is dropped and never replayed, the client
+				// only hears from the game server again once it resumes
+				if clientC == nil {
+					break
+				}
+
+				n, err := pkt.Into(w.cConn)
+				m.stats.gameToClient(n)
 				if err != nil {
-					m.removeConnection(w, err)
+					dropClient(err)
 				}
 			}
-		case pkt := <-w.cFramer.C:
+			pkt.Release()
+		case pkt, ok := <-clientC:
+			if !ok {
+				if w.ctx.Err() != nil {
+					break
+				}
+
+				dropClient(m.framerError(w, w.cFramer, "client"))
+				break
+			}
+
 			switch pkt.Type() {
+			case packet.PacketPing:
+				pong := packet.CreatePong(pkt)
+				if _, err := pong.Into(w.cConn); err != nil {
+					dropClient(err)
+				}
+			case packet.PacketPong:
+				m.pong(w, w.cHeartbeat, pkt, "client")
 			case packet.PacketCloseConnection:
-				_, err := pkt.Into(w.gConn)
+				n, err := pkt.IntoWithIntegrity(w.gConn, w.gIntegrity)
+				m.stats.clientToGame(n)
 				m.removeConnection(w, err)
 			default:
-				_, err := pkt.Into(w.gConn)
+				n, err := pkt.IntoWithIntegrity(w.gConn, w.gIntegrity)
+				m.stats.clientToGame(n)
 				if err != nil {
 					m.removeConnection(w, err)
 				}
 			}
+			pkt.Release()
+		case <-clientBeat:
+			if err := m.beat(w, w.cHeartbeat, w.cConn, nil, "client", packet.HeartbeatMissed); err != nil {
+				dropClient(err)
+			}
+		case <-w.gHeartbeat.C():
+			if err := m.beat(w, w.gHeartbeat, w.gConn, w.gIntegrity, "game", AMProxyGameServerClosed); err != nil {
+				m.removeConnection(w, err)
+			}
+		case client := <-w.resumed:
+			w.setClient(client.conn)
+			w.cFramer = client.framer
+			w.cHeartbeat.Stop()
+			w.cHeartbeat = packet.NewHeartbeat(m.heartbeat)
+
+			clientC = w.cFramer.C
+			clientBeat = w.cHeartbeat.C()
+			parkExpired = nil
+
+			resp := packet.CreateServerAuthAccepted(w.gsId, m.newResumeToken(w))
+			if _, err := resp.Into(w.cConn); err != nil {
+				dropClient(err)
+			}
+		case <-parkExpired:
+			parkExpired = nil
+
+			// a resume that already took the connection is on its way
+			if m.unpark(w) {
+				w.logger.Warn("client never resumed, giving up its game server", "server-id", w.gsId)
+				pkt := packet.CreateCloseConnection()
+				_, _ = pkt.IntoWithIntegrity(w.gConn, w.gIntegrity)
+				m.removeConnection(w, nil)
+			}
+		case <-m.draining:
+			m.closeGracefully(w)
 		case <-w.ctx.Done():
-			m.logger.Info("connection finished", "server-id", w.gsId)
 		}
 	}
 }
 
+// closeGracefully tells both sides the connection is over, exactly like a
+// client closing the connection itself
+func (m *AMProxy) closeGracefully(w *AMConnectionWrapper) {
+	pkt := packet.CreateCloseConnection()
+	if _, err := pkt.IntoWithIntegrity(w.gConn, w.gIntegrity); err != nil {
+		w.logger.Error("could not write close into game server", "server-id", w.gsId, "error", err)
+	}
+
+	if _, err := pkt.Into(w.cConn); err != nil {
+		w.logger.Error("could not write close into client", "server-id", w.gsId, "error", err)
+	}
+
+	m.removeConnection(w, nil)
+}
+
+func (m *AMProxy) IsDraining() bool {
+	select {
+	case <-m.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain closes every connection with a PacketCloseConnection and refuses new
+// ones.  It waits for connections to finish closing until ctx is done, then
+// whatever is left is cut off.  The proxy itself is left for the caller to
+// close
+func (m *AMProxy) Drain(ctx context.Context) error {
+	m.drainOnce.Do(func() {
+		m.logger.Warn("draining", "connections", m.stats.activeConnections.Load())
+		close(m.draining)
+	})
+
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+
+	for m.stats.activeConnections.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			m.logger.Error("drain deadline reached, cutting off connections", "connections", m.stats.activeConnections.Load())
+			m.cutOff()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	m.logger.Warn("drained")
+	return nil
+}
+
+func (m *AMProxy) cutOff() {
+	m.connsM.Lock()
+	conns := make([]*AMConnectionWrapper, 0, len(m.conns))
+	for _, w := range m.conns {
+		conns = append(conns, w)
+	}
+	m.connsM.Unlock()
+
+	// closing removes from conns, can't hold the lock
+	for _, w := range conns {
+		w.Close()
+	}
+}
+
 func (m *AMProxy) Close() {
 	m.logger.Warn("closing down")
 	m.closed = true
@@ -0,0 +1,210 @@
+package amproxy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const HMAC_TOKEN_SIZE = 8 + sha256.Size
+
+var AMProxyAuthMissing = fmt.Errorf("authentication token missing")
+var AMProxyAuthInvalid = fmt.Errorf("authentication token invalid")
+var AMProxyAuthExpired = fmt.Errorf("authentication token expired")
+var AMProxyAuthKeyInvalid = fmt.Errorf("authentication key invalid")
+
+// Authenticator decides if the client behind the id of a PacketClientAuth is
+// who they say they are.  The token is whatever followed the id, possibly
+// nothing
+type Authenticator interface {
+	Authenticate(id []byte, token []byte) error
+}
+
+// authRejectReason is what the client gets told.  The details stay in the
+// proxy logs
+func authRejectReason(err error) string {
+	for _, reason := range []error{AMProxyAuthMissing, AMProxyAuthExpired, AMProxyAuthInvalid, AMProxyResumeInvalid} {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return AMProxyAuthInvalid.Error()
+}
+
+// HMACTokenAuthenticator verifies tokens minted by CreateHMACToken with a
+// secret shared between the proxy and whatever hands out tokens
+type HMACTokenAuthenticator struct {
+	secret []byte
+}
+
+func NewHMACTokenAuthenticator(secret []byte) *HMACTokenAuthenticator {
+	return &HMACTokenAuthenticator{secret: secret}
+}
+
+func hmacTokenSum(secret []byte, id []byte, expires []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(id)
+	mac.Write(expires)
+	return mac.Sum(nil)
+}
+
+// CreateHMACToken is the 8 byte expiry in unix seconds followed by the
+// HMAC-SHA256 of the id and the expiry
+func CreateHMACToken(secret []byte, id []byte, expires time.Time) []byte {
+	token := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	return append(token, hmacTokenSum(secret, id, token)...)
+}
+
+func (h *HMACTokenAuthenticator) Authenticate(id []byte, token []byte) error {
+	if len(token) == 0 {
+		return AMProxyAuthMissing
+	}
+
+	if len(token) != HMAC_TOKEN_SIZE {
+		return errors.Join(AMProxyAuthInvalid, fmt.Errorf("token length: %d", len(token)))
+	}
+
+	expires := token[:8]
+	if !hmac.Equal(hmacTokenSum(h.secret, id, expires), token[8:]) {
+		return AMProxyAuthInvalid
+	}
+
+	if time.Now().Unix() >= int64(binary.BigEndian.Uint64(expires)) {
+		return AMProxyAuthExpired
+	}
+
+	return nil
+}
+
+type JWTClaims struct {
+	// hex encoded client id
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWTAuthenticator verifies compact JWTs whose subject is the hex encoded
+// client id.  Only EdDSA (ed25519) and HS256 are understood, which one depends
+// on the key it was created with
+type JWTAuthenticator struct {
+	alg       string
+	publicKey ed25519.PublicKey
+	secret    []byte
+}
+
+// NewJWTAuthenticatorFromFile reads either a PEM encoded ed25519 public key
+// or, for anything that isn't PEM, an HS256 secret
+func NewJWTAuthenticatorFromFile(path string) (*JWTAuthenticator, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Join(AMProxyAuthKeyInvalid, err)
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		secret := bytes.TrimSpace(contents)
+		if len(secret) == 0 {
+			return nil, errors.Join(AMProxyAuthKeyInvalid, fmt.Errorf("key file is empty: %s", path))
+		}
+		return &JWTAuthenticator{alg: "HS256", secret: secret}, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Join(AMProxyAuthKeyInvalid, err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.Join(AMProxyAuthKeyInvalid, fmt.Errorf("unsupported key type: %T", key))
+	}
+
+	return &JWTAuthenticator{alg: "EdDSA", publicKey: publicKey}, nil
+}
+
+func (j *JWTAuthenticator) verify(signed []byte, signature []byte) bool {
+	switch j.alg {
+	case "EdDSA":
+		return ed25519.Verify(j.publicKey, signed, signature)
+	case "HS256":
+		mac := hmac.New(sha256.New, j.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	return false
+}
+
+func decodeJWTPart(part string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (j *JWTAuthenticator) Authenticate(id []byte, token []byte) error {
+	if len(token) == 0 {
+		return AMProxyAuthMissing
+	}
+
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return errors.Join(AMProxyAuthInvalid, fmt.Errorf("expected 3 parts, received %d", len(parts)))
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return errors.Join(AMProxyAuthInvalid, err)
+	}
+
+	// never let the token pick the algorithm
+	if header.Alg != j.alg {
+		return errors.Join(AMProxyAuthInvalid, fmt.Errorf("unexpected alg: %s", header.Alg))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.Join(AMProxyAuthInvalid, err)
+	}
+
+	if !j.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return AMProxyAuthInvalid
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return errors.Join(AMProxyAuthInvalid, err)
+	}
+
+	if claims.Subject != hex.EncodeToString(id) {
+		return errors.Join(AMProxyAuthInvalid, fmt.Errorf("subject does not match id: %s", claims.Subject))
+	}
+
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+		return AMProxyAuthExpired
+	}
+
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return errors.Join(AMProxyAuthInvalid, fmt.Errorf("token not valid before: %d", claims.NotBefore))
+	}
+
+	return nil
+}
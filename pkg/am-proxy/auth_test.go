@@ -0,0 +1,133 @@
+package amproxy_test
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	amproxy "vim-arcade.theprimeagen.com/pkg/am-proxy"
+)
+
+var id = []byte("0123456789abcdef")
+var otherId = []byte("fedcba9876543210")
+
+func TestHMACTokenAuthenticator(t *testing.T) {
+	secret := []byte("shh")
+	auth := amproxy.NewHMACTokenAuthenticator(secret)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name  string
+		id    []byte
+		token []byte
+		err   error
+	}{
+		{"valid", id, amproxy.CreateHMACToken(secret, id, future), nil},
+		{"missing", id, nil, amproxy.AMProxyAuthMissing},
+		{"short", id, []byte{1, 2, 3}, amproxy.AMProxyAuthInvalid},
+		{"wrong id", otherId, amproxy.CreateHMACToken(secret, id, future), amproxy.AMProxyAuthInvalid},
+		{"wrong secret", id, amproxy.CreateHMACToken([]byte("nope"), id, future), amproxy.AMProxyAuthInvalid},
+		{"expired", id, amproxy.CreateHMACToken(secret, id, time.Now().Add(-time.Second)), amproxy.AMProxyAuthExpired},
+	}
+
+	for _, tt := range tests {
+		err := auth.Authenticate(tt.id, tt.token)
+		if tt.err == nil {
+			require.NoError(t, err, tt.name)
+		} else {
+			require.ErrorIs(t, err, tt.err, tt.name)
+		}
+	}
+}
+
+func jwtPart(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signJWT(t *testing.T, alg string, claims amproxy.JWTClaims, sign func([]byte) []byte) []byte {
+	signed := jwtPart(t, map[string]string{"alg": alg, "typ": "JWT"}) + "." + jwtPart(t, claims)
+	return []byte(signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed))))
+}
+
+func writeKeyFile(t *testing.T, contents []byte) string {
+	file := path.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(file, contents, 0600))
+	return file
+}
+
+func TestJWTAuthenticatorEdDSA(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	require.NoError(t, err)
+
+	auth, err := amproxy.NewJWTAuthenticatorFromFile(writeKeyFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	require.NoError(t, err)
+
+	_, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	sign := func(data []byte) []byte { return ed25519.Sign(private, data) }
+	claims := amproxy.JWTClaims{Subject: hex.EncodeToString(id), ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+
+	tests := []struct {
+		name  string
+		id    []byte
+		token []byte
+		err   error
+	}{
+		{"valid", id, signJWT(t, "EdDSA", claims, sign), nil},
+		{"missing", id, nil, amproxy.AMProxyAuthMissing},
+		{"garbage", id, []byte("not.a.jwt"), amproxy.AMProxyAuthInvalid},
+		{"wrong subject", otherId, signJWT(t, "EdDSA", claims, sign), amproxy.AMProxyAuthInvalid},
+		{"wrong key", id, signJWT(t, "EdDSA", claims, func(data []byte) []byte { return ed25519.Sign(otherPrivate, data) }), amproxy.AMProxyAuthInvalid},
+		{"alg swap", id, signJWT(t, "HS256", claims, sign), amproxy.AMProxyAuthInvalid},
+		{"expired", id, signJWT(t, "EdDSA", expired, sign), amproxy.AMProxyAuthExpired},
+	}
+
+	for _, tt := range tests {
+		err := auth.Authenticate(tt.id, tt.token)
+		if tt.err == nil {
+			require.NoError(t, err, tt.name)
+		} else {
+			require.ErrorIs(t, err, tt.err, tt.name)
+		}
+	}
+}
+
+func TestJWTAuthenticatorHS256(t *testing.T) {
+	secret := []byte("shh")
+	auth, err := amproxy.NewJWTAuthenticatorFromFile(writeKeyFile(t, append(secret, '\n')))
+	require.NoError(t, err)
+
+	sign := func(data []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+
+	claims := amproxy.JWTClaims{Subject: hex.EncodeToString(id)}
+	require.NoError(t, auth.Authenticate(id, signJWT(t, "HS256", claims, sign)))
+	require.ErrorIs(t, auth.Authenticate(id, signJWT(t, "EdDSA", claims, sign)), amproxy.AMProxyAuthInvalid)
+
+	_, err = amproxy.NewJWTAuthenticatorFromFile(writeKeyFile(t, []byte("  \n")))
+	require.ErrorIs(t, err, amproxy.AMProxyAuthKeyInvalid)
+
+	_, err = amproxy.NewJWTAuthenticatorFromFile(path.Join(t.TempDir(), "missing"))
+	require.ErrorIs(t, err, amproxy.AMProxyAuthKeyInvalid)
+}
@@ -0,0 +1,120 @@
+package amproxy
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+// creation is a game server on its way to ready.  Every connection that
+// needs a new server subscribes to one and they all get the same result
+type creation struct {
+	game   gameserverstats.GameType
+	done   chan struct{}
+	gameId string
+	err    error
+
+	// never more than the server's capacity
+	subscribers int
+}
+
+// subscription is one subscriber's share of a creation
+type subscription struct {
+	broker      *creationBroker
+	creation    *creation
+	connections int
+}
+
+// wait gives up the subscriber's share when ctx is done first, so the room
+// goes to whoever subscribes next
+func (s *subscription) wait(ctx context.Context) (string, error) {
+	select {
+	case <-s.creation.done:
+		return s.creation.gameId, s.creation.err
+	case <-ctx.Done():
+		s.broker.leave(s)
+		return "", ctx.Err()
+	}
+}
+
+// creationBroker starts as many servers as it takes to fit everyone waiting
+// on one, a new creation starts once the others are full
+type creationBroker struct {
+	mutex    sync.Mutex
+	servers  GameServer
+	logger   *slog.Logger
+	inFlight []*creation
+}
+
+func newCreationBroker(servers GameServer) *creationBroker {
+	return &creationBroker{
+		servers:  servers,
+		logger:   slog.Default().With("area", "CreationBroker"),
+		inFlight: []*creation{},
+	}
+}
+
+// subscribe joins a creation of the same game with room left for all of the
+// connections or starts a new one.  ctx is what the created server lives for,
+// not how long the subscriber waits
+func (b *creationBroker) subscribe(ctx context.Context, game gameserverstats.GameType, connections int) *subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// 0 is a server without a known capacity, everyone shares it
+	capacity := b.servers.Capacity(game)
+	for _, c := range b.inFlight {
+		if c.game != game {
+			continue
+		}
+		if capacity == 0 || c.subscribers+connections <= capacity {
+			c.subscribers += connections
+			return &subscription{broker: b, creation: c, connections: connections}
+		}
+	}
+
+	c := &creation{
+		game:        game,
+		done:        make(chan struct{}),
+		subscribers: connections,
+	}
+	b.inFlight = append(b.inFlight, c)
+	b.logger.Info("starting creation", "game", game.String(), "in-flight", len(b.inFlight), "capacity", capacity)
+
+	go b.create(ctx, c)
+	return &subscription{broker: b, creation: c, connections: connections}
+}
+
+func (b *creationBroker) leave(s *subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s.creation.subscribers -= s.connections
+}
+
+func (b *creationBroker) create(ctx context.Context, c *creation) {
+	gameId, err := b.servers.CreateNewServer(ctx, c.game)
+	if err == nil {
+		b.logger.Info("waiting for server", "id", gameId)
+		err = b.servers.WaitForReady(ctx, gameId)
+	}
+
+	b.mutex.Lock()
+	idx := slices.Index(b.inFlight, c)
+	b.inFlight = slices.Delete(b.inFlight, idx, idx+1)
+	subscribers := c.subscribers
+	b.mutex.Unlock()
+
+	if err != nil {
+		b.logger.Warn("unable to create server", "error", err, "subscribers", subscribers)
+		gameId = ""
+	} else {
+		b.logger.Info("server created", "id", gameId, "subscribers", subscribers)
+	}
+
+	c.gameId = gameId
+	c.err = err
+	close(c.done)
+}
@@ -0,0 +1,204 @@
+package amproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+// fakeCreator creates servers once release is closed
+type fakeCreator struct {
+	GameServer
+
+	mutex    sync.Mutex
+	created  int
+	games    []gameserverstats.GameType
+	capacity int
+	err      error
+	readyErr error
+	release  chan struct{}
+}
+
+func (f *fakeCreator) Capacity(game gameserverstats.GameType) int {
+	return f.capacity
+}
+
+func (f *fakeCreator) CreateNewServer(ctx context.Context, game gameserverstats.GameType) (string, error) {
+	<-f.release
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return "", f.err
+	}
+
+	f.created++
+	f.games = append(f.games, game)
+	return fmt.Sprintf("%d", f.created), nil
+}
+
+func (f *fakeCreator) WaitForReady(ctx context.Context, id string) error {
+	return f.readyErr
+}
+
+func subscribeAll(broker *creationBroker, game gameserverstats.GameType, count int) []*subscription {
+	out := []*subscription{}
+	for range count {
+		out = append(out, broker.subscribe(context.Background(), game, 1))
+	}
+	return out
+}
+
+func TestBrokerCreatesEnoughForEveryone(t *testing.T) {
+	creator := &fakeCreator{capacity: 2, release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	creations := subscribeAll(broker, gameserverstats.DefaultGame, 5)
+	close(creator.release)
+
+	servers := map[string]int{}
+	for _, c := range creations {
+		id, err := c.wait(context.Background())
+		require.NoError(t, err)
+		servers[id]++
+	}
+
+	// which creation gets which id is up to the scheduler
+	sizes := []int{}
+	for _, count := range servers {
+		sizes = append(sizes, count)
+	}
+
+	require.Equal(t, 3, creator.created)
+	require.ElementsMatch(t, []int{2, 2, 1}, sizes)
+	require.Empty(t, broker.inFlight)
+}
+
+func TestBrokerUnknownCapacityShares(t *testing.T) {
+	creator := &fakeCreator{release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	creations := subscribeAll(broker, gameserverstats.DefaultGame, 10)
+	close(creator.release)
+
+	for _, c := range creations {
+		id, err := c.wait(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "1", id)
+	}
+	require.Equal(t, 1, creator.created)
+}
+
+func TestBrokerSharesErrors(t *testing.T) {
+	limit := errors.New("no more")
+	creator := &fakeCreator{capacity: 5, err: limit, release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	creations := subscribeAll(broker, gameserverstats.DefaultGame, 3)
+	close(creator.release)
+
+	for _, c := range creations {
+		_, err := c.wait(context.Background())
+		require.ErrorIs(t, err, limit)
+	}
+
+	// a failed creation is not reused
+	creator.mutex.Lock()
+	creator.err = nil
+	creator.mutex.Unlock()
+
+	id, err := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 1).wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "1", id)
+}
+
+func TestBrokerWaitGivesUp(t *testing.T) {
+	creator := &fakeCreator{capacity: 1, release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 1)
+	cancel()
+
+	_, err := c.wait(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// the room it gave up goes to the next one instead of another server
+	next := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 1)
+	require.Same(t, c.creation, next.creation)
+	close(creator.release)
+
+	_, err = next.wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, creator.created)
+}
+
+func TestBrokerServerClosedBeforeReady(t *testing.T) {
+	creator := &fakeCreator{capacity: 2, readyErr: servermanagement.ServerClosed, release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	creations := subscribeAll(broker, gameserverstats.DefaultGame, 2)
+	close(creator.release)
+
+	for _, c := range creations {
+		id, err := c.wait(context.Background())
+		require.ErrorIs(t, err, servermanagement.ServerClosed)
+		require.Empty(t, id)
+	}
+}
+
+func TestBrokerNeverMixesGames(t *testing.T) {
+	creator := &fakeCreator{release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+	other := gameserverstats.GameType{Name: "other", Version: 1}
+
+	defaults := subscribeAll(broker, gameserverstats.DefaultGame, 2)
+	others := subscribeAll(broker, other, 2)
+	close(creator.release)
+
+	defaultId, err := defaults[0].wait(context.Background())
+	require.NoError(t, err)
+	otherId, err := others[0].wait(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, defaultId, otherId)
+
+	for _, c := range defaults {
+		id, _ := c.wait(context.Background())
+		require.Equal(t, defaultId, id)
+	}
+	for _, c := range others {
+		id, _ := c.wait(context.Background())
+		require.Equal(t, otherId, id)
+	}
+
+	require.ElementsMatch(t, []gameserverstats.GameType{gameserverstats.DefaultGame, other}, creator.games)
+}
+
+func TestBrokerFitsGroups(t *testing.T) {
+	creator := &fakeCreator{capacity: 3, release: make(chan struct{})}
+	broker := newCreationBroker(creator)
+
+	single := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 1)
+	party := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 3)
+	pair := broker.subscribe(context.Background(), gameserverstats.DefaultGame, 2)
+	close(creator.release)
+
+	singleId, err := single.wait(context.Background())
+	require.NoError(t, err)
+	partyId, err := party.wait(context.Background())
+	require.NoError(t, err)
+	pairId, err := pair.wait(context.Background())
+	require.NoError(t, err)
+
+	// the party never fits next to anyone, the pair fits next to the single
+	require.Equal(t, 2, creator.created)
+	require.NotEqual(t, singleId, partyId)
+	require.Equal(t, singleId, pairId)
+}
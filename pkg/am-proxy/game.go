@@ -3,24 +3,42 @@ package amproxy
 import (
 	"context"
 	"io"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
 )
 
-// TODO consider all of these operations with game type
-// there will possibly be a day where i have more than one game type
+// a server hosts exactly one game type, so everything keyed by id already
+// knows its game
 //go:generate mockery --name GameServer
 type GameServer interface {
-	GetBestServer() (string, error)
-	CreateNewServer(ctx context.Context) (string, error)
+	// a server with room for all of the connections
+	GetBestServer(game gameserverstats.GameType, connections int) (string, error)
+	CreateNewServer(ctx context.Context, game gameserverstats.GameType) (string, error)
 	WaitForReady(ctx context.Context, id string) error
 	GetConnectionString(id string) (string, error)
+
+	// connections a new server takes before it is full, 0 when unknown
+	Capacity(game gameserverstats.GameType) int
+
+	// a draining server is never returned from GetBestServer
+	SetDraining(id string, draining bool) error
+	IsDraining(id string) bool
 	//ListServers() []gameserverstats.GameServerConfig
 	String() string
 }
 
 type AMConnection interface {
     io.ReadWriteCloser
+
+    // the remote address
 	Addr() string
+
+    // unique to the connection from the moment it is accepted
     Id() string
+
+    // the id the client authenticated with, empty until then
+    ClientId() string
+    SetClientId(id string)
 }
 
 type ConnectionFactory func(string) (AMConnection, error)
@@ -0,0 +1,141 @@
+package amproxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var AMProxyRateLimited = fmt.Errorf("too many connections from this address, slow down")
+var AMProxyAddressLimited = fmt.Errorf("too many concurrent connections from this address")
+var AMProxyAtCapacity = fmt.Errorf("proxy is at capacity")
+
+// idle addresses are forgotten once their bucket refills, this is just how
+// often we go looking for them
+const limiterPruneInterval = time.Minute
+
+// ConnectionLimits are checked before a connection is ever read from.  Zero
+// for any of the limits means unlimited
+type ConnectionLimits struct {
+	// token bucket per remote ip, refilled at RatePerSecond up to Burst
+	RatePerSecond float64 `json:"ratePerSecond"`
+	Burst         int     `json:"burst"`
+
+	MaxPerIP int `json:"maxPerIP"`
+	MaxTotal int `json:"maxTotal"`
+}
+
+type ipLimit struct {
+	tokens float64
+	last   time.Time
+	active int
+}
+
+type connectionLimiter struct {
+	mutex     sync.Mutex
+	limits    ConnectionLimits
+	ips       map[string]*ipLimit
+	total     int
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func newConnectionLimiter(limits ConnectionLimits) *connectionLimiter {
+	if limits.RatePerSecond > 0 && limits.Burst < 1 {
+		limits.Burst = 1
+	}
+
+	return &connectionLimiter{
+		limits:    limits,
+		ips:       map[string]*ipLimit{},
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// remoteIP drops the port so every connection from the same host shares a
+// bucket
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func (l *connectionLimiter) refill(ip *ipLimit, now time.Time) {
+	if l.limits.RatePerSecond <= 0 {
+		return
+	}
+
+	ip.tokens += now.Sub(ip.last).Seconds() * l.limits.RatePerSecond
+	ip.tokens = min(ip.tokens, float64(l.limits.Burst))
+	ip.last = now
+}
+
+func (l *connectionLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < limiterPruneInterval {
+		return
+	}
+
+	l.lastPrune = now
+	for addr, ip := range l.ips {
+		l.refill(ip, now)
+		if ip.active == 0 && ip.tokens >= float64(l.limits.Burst) {
+			delete(l.ips, addr)
+		}
+	}
+}
+
+// acquire has to be paired with a release when it doesn't error
+func (l *connectionLimiter) acquire(addr string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	if l.limits.MaxTotal > 0 && l.total >= l.limits.MaxTotal {
+		return AMProxyAtCapacity
+	}
+
+	key := remoteIP(addr)
+	ip, ok := l.ips[key]
+	if !ok {
+		ip = &ipLimit{tokens: float64(l.limits.Burst), last: now}
+		l.ips[key] = ip
+	}
+
+	if l.limits.MaxPerIP > 0 && ip.active >= l.limits.MaxPerIP {
+		return AMProxyAddressLimited
+	}
+
+	if l.limits.RatePerSecond > 0 {
+		l.refill(ip, now)
+		if ip.tokens < 1 {
+			return AMProxyRateLimited
+		}
+		ip.tokens -= 1
+	}
+
+	ip.active += 1
+	l.total += 1
+	return nil
+}
+
+func (l *connectionLimiter) release(addr string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.total -= 1
+	if ip, ok := l.ips[remoteIP(addr)]; ok {
+		ip.active -= 1
+	}
+}
+
+func (l *connectionLimiter) trackedIPs() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.ips)
+}
@@ -0,0 +1,96 @@
+package amproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func testLimiter(limits ConnectionLimits) (*connectionLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(69420, 0)}
+	limiter := newConnectionLimiter(limits)
+	limiter.now = clock.Now
+	limiter.lastPrune = clock.now
+	return limiter, clock
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter, _ := testLimiter(ConnectionLimits{})
+	for range 1000 {
+		require.NoError(t, limiter.acquire("127.0.0.1:1337"))
+	}
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	limiter, clock := testLimiter(ConnectionLimits{RatePerSecond: 2, Burst: 3})
+
+	for range 3 {
+		require.NoError(t, limiter.acquire("10.0.0.1:1"))
+	}
+	require.ErrorIs(t, limiter.acquire("10.0.0.1:2"), AMProxyRateLimited)
+
+	// a different ip has its own bucket
+	require.NoError(t, limiter.acquire("10.0.0.2:1"))
+
+	// releasing does not refund tokens, only time does
+	limiter.release("10.0.0.1:1")
+	require.ErrorIs(t, limiter.acquire("10.0.0.1:3"), AMProxyRateLimited)
+
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	require.NoError(t, limiter.acquire("10.0.0.1:4"))
+	require.ErrorIs(t, limiter.acquire("10.0.0.1:5"), AMProxyRateLimited)
+
+	// never refills past the burst
+	clock.now = clock.now.Add(time.Hour)
+	for range 3 {
+		require.NoError(t, limiter.acquire("10.0.0.1:6"))
+	}
+	require.ErrorIs(t, limiter.acquire("10.0.0.1:7"), AMProxyRateLimited)
+}
+
+func TestLimiterMaxPerIP(t *testing.T) {
+	limiter, _ := testLimiter(ConnectionLimits{MaxPerIP: 2})
+
+	require.NoError(t, limiter.acquire("10.0.0.1:1"))
+	require.NoError(t, limiter.acquire("10.0.0.1:2"))
+	require.ErrorIs(t, limiter.acquire("10.0.0.1:3"), AMProxyAddressLimited)
+	require.NoError(t, limiter.acquire("10.0.0.2:1"))
+
+	limiter.release("10.0.0.1:1")
+	require.NoError(t, limiter.acquire("10.0.0.1:4"))
+}
+
+func TestLimiterMaxTotal(t *testing.T) {
+	limiter, _ := testLimiter(ConnectionLimits{MaxTotal: 2})
+
+	require.NoError(t, limiter.acquire("10.0.0.1:1"))
+	require.NoError(t, limiter.acquire("10.0.0.2:1"))
+	require.ErrorIs(t, limiter.acquire("10.0.0.3:1"), AMProxyAtCapacity)
+
+	limiter.release("10.0.0.2:1")
+	require.NoError(t, limiter.acquire("10.0.0.3:1"))
+}
+
+func TestLimiterPrunesIdleAddresses(t *testing.T) {
+	limiter, clock := testLimiter(ConnectionLimits{RatePerSecond: 1, Burst: 1})
+
+	require.NoError(t, limiter.acquire("10.0.0.1:1"))
+	require.NoError(t, limiter.acquire("10.0.0.2:1"))
+	limiter.release("10.0.0.1:1")
+	require.Equal(t, 2, limiter.trackedIPs())
+
+	clock.now = clock.now.Add(limiterPruneInterval)
+	require.NoError(t, limiter.acquire("10.0.0.3:1"))
+
+	// .2 still has a connection open
+	require.Equal(t, 2, limiter.trackedIPs())
+}
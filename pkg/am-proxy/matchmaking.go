@@ -6,128 +6,299 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
-	"sync"
+	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	"vim-arcade.theprimeagen.com/pkg/packet"
 	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
 )
 
+var MatchmakingServerGone = fmt.Errorf("game server went away before the connection got to it")
+
+// how many servers a connection is given before a server going away between
+// being picked and being connected to is a failure
+const MATCHMAKE_ATTEMPTS = 3
+
 type MatchMakingServer struct {
 	servers  GameServer
 	logger   *slog.Logger
 	listener net.Listener
 	ready    bool
 
-	waitingForServer bool
+	// every connection that needs a new server goes through here
+	broker *creationBroker
+
+	// connections waiting on a server once no more can be created
+	queue *matchQueue
+
+	// connections waiting on the rest of their party
+	parties *partyHolder
 
-	mutex             sync.Mutex
-	wait              sync.WaitGroup
-	lastCreatedGameId string
+	// how long connections spend in createAndWait, waiters included
+	createWait *latencyHistogram
 }
 
-func (m *MatchMakingServer) startWaiting() bool {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// createAndWait blocks until the server the connection subscribed to is
+// ready, or waitCtx is done.  ctx is what created servers live for
+func (m *MatchMakingServer) createAndWait(ctx context.Context, waitCtx context.Context, game gameserverstats.GameType, connections int) (string, error) {
+	m.logger.Info("going to create and wait for new game server", "game", game.String(), "connections", connections)
+	defer m.createWait.since(time.Now())
 
-	if !m.waitingForServer {
-		m.waitingForServer = true
-		m.wait = sync.WaitGroup{}
-		m.wait.Add(1)
-		return true
-	}
+	// TODO messaging goes way better...
+	// TODO horizontal scaling can be quite difficult for the current method
+	return m.broker.subscribe(ctx, game, connections).wait(waitCtx)
+}
 
-	return false
+// findServer creates a server when none of the existing ones of the game have
+// room for the connections
+func (m *MatchMakingServer) findServer(ctx context.Context, waitCtx context.Context, game gameserverstats.GameType, connections int) (string, error) {
+	gameId, err := m.servers.GetBestServer(game, connections)
+	if errors.Is(err, servermanagement.NoBestServer) {
+		return m.createAndWait(ctx, waitCtx, game, connections)
+	}
+	return gameId, err
 }
 
-func (m *MatchMakingServer) stopWaiting() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// waitInQueue holds the connection until the queue assigns it a game server.
+// ctx is what created servers live for, connCtx is the connection's
+func (m *MatchMakingServer) waitInQueue(ctx context.Context, connCtx context.Context, conn AMConnection, game gameserverstats.GameType) (string, error) {
+	queued, startPolling, err := m.queue.enqueue(conn, game)
+	if err != nil {
+		m.connLogger(conn).Warn("queue is full", "size", m.queue.config.Size)
+		return "", err
+	}
+
+	if startPolling {
+		go m.pollQueue(ctx)
+	}
 
-	if !m.waitingForServer {
-		return
+	// a nil channel never fires, so no timeout means wait forever
+	var timeout <-chan time.Time
+	if m.queue.config.Timeout > 0 {
+		timer := time.NewTimer(m.queue.config.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	m.wait.Done()
-	m.waitingForServer = false
+	for {
+		select {
+		case gameId := <-queued.assigned:
+			m.connLogger(conn).Info("assigned from queue", "gameId", gameId)
+			return gameId, nil
+		case position := <-queued.position:
+			m.connLogger(conn).Info("queue position", "position", position)
+			msg, err := packet.CreateMessage(queuePositionMessage(position))
+			assert.NoError(err, "queue position message should always fit in a packet")
+			if _, err := msg.Into(conn); err != nil {
+				m.queue.remove(queued)
+				return "", err
+			}
+		case <-timeout:
+			if m.queue.remove(queued) {
+				m.connLogger(conn).Warn("timed out in queue", "timeout", m.queue.config.Timeout)
+				return "", MatchmakingQueueTimeout
+			}
+			return <-queued.assigned, nil
+		case <-connCtx.Done():
+			// the connection is gone, an assignment it got on the way out
+			// goes unused
+			m.queue.remove(queued)
+			return "", connCtx.Err()
+		}
+	}
 }
 
-func (m *MatchMakingServer) createAndWait(ctx context.Context) string {
-	m.logger.Info("going to create and wait for new game server")
-	if !m.startWaiting() {
-		m.logger.Info("already waiting on server")
-		m.wait.Wait()
-		m.logger.Info("waited for server to be created", "id", m.lastCreatedGameId)
-		return m.lastCreatedGameId
+// pollQueue hands out game servers to the first connection waiting on each
+// game, one per game per poll, so a server's load has a chance to catch up
+// before it is picked again
+func (m *MatchMakingServer) pollQueue(ctx context.Context) {
+	ticker := time.NewTicker(QUEUE_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if m.queue.stopPolling() {
+			return
+		}
+
+		for _, game := range m.queue.games() {
+			gameId, err := m.findServer(ctx, ctx, game, 1)
+			if err != nil {
+				continue
+			}
+
+			if queued := m.queue.assign(game, gameId); queued != nil {
+				m.connLogger(queued.conn).Info("queue head assigned", "game", game.String(), "gameId", gameId)
+			}
+		}
 	}
+}
 
-	// TODO messaging goes way better...
-	// TODO horizontal scaling can be quite difficult for the current method
-	gameId, err := m.servers.CreateNewServer(ctx)
+func (m *MatchMakingServer) QueueLength() int {
+	return m.queue.length()
+}
 
-	// seee i hate this method.. it feels very prone to failure...
-	m.lastCreatedGameId = gameId
+// PartiesWaiting are the parties yet to fill up
+func (m *MatchMakingServer) PartiesWaiting() int {
+	return m.parties.length()
+}
+
+// waitForParty holds the connection until everyone in its party showed up,
+// then the whole party gets one server.  Parties never wait in the queue
+func (m *MatchMakingServer) waitForParty(ctx context.Context, connCtx context.Context, conn AMConnection, req MatchRequest) (string, error) {
+	capacity := m.servers.Capacity(req.Game)
+	if req.PartySize < 1 || (capacity != 0 && req.PartySize > capacity) {
+		return "", fmt.Errorf("%w: %d players, servers fit %d", MatchmakingPartyTooLarge, req.PartySize, capacity)
+	}
 
+	p, complete, err := m.parties.join(req.Party, req.Game, req.PartySize)
 	if err != nil {
-		// TODO If there are no more servers available to create (max server count)
-		// then the queue needs to begin
-		assert.Never("unimplemented")
+		m.connLogger(conn).Warn("unable to join party", "party", req.Party, "error", err)
+		return "", err
+	}
 
-        // i am thinking that i am going to have to share the error across the
-        // connections
+	m.connLogger(conn).Info("joined party", "party", req.Party, "members", p.members, "size", p.size)
+	if complete {
+		// the party is not done once this connection leaves, so ctx
+		gameId, err := m.findServer(ctx, ctx, p.game, p.size)
+		m.logger.Info("party complete", "party", p.id, "gameId", gameId, "error", err)
+		p.finish(gameId, err)
+		return p.wait()
 	}
 
-	m.logger.Info("waiting for server", "id", gameId)
-	err = m.servers.WaitForReady(ctx, gameId)
-	m.logger.Info("server created", "id", gameId)
-	assert.NoError(err, "i need to be able to handle the issue of failing to create server or the server cannot ready")
+	timer := time.NewTimer(time.Until(p.deadline))
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+	case <-timer.C:
+		m.parties.expire(p)
+	case <-connCtx.Done():
+		if m.parties.leave(p) {
+			return "", connCtx.Err()
+		}
+	}
 
-	m.stopWaiting()
-	return gameId
+	return p.wait()
+}
+
+// MatchRequest is what a connection asked matchmaking for
+type MatchRequest struct {
+	Game gameserverstats.GameType
+
+	// empty plays alone
+	Party     string
+	PartySize int
 }
 
 type GameConnectionInfo struct {
     Id string
     Addr string
+
+    // time spent in the matchmaking queue, 0 when there was room right away
+    Queued time.Duration
 }
 
 // TODO(v1) create no garbage ([]byte...)
-func (m *MatchMakingServer) matchmake(ctx context.Context, conn AMConnection) (*GameConnectionInfo, error) {
-    connId := conn.Id()
-	gameId, err := m.servers.GetBestServer()
+func (m *MatchMakingServer) matchmake(ctx context.Context, connCtx context.Context, conn AMConnection, req MatchRequest) (*GameConnectionInfo, error) {
+    logger := m.connLogger(conn)
+	game := req.Game
 
-	m.logger.Info("getting best server", "gameId", gameId, "error", err, "id", connId)
-	if errors.Is(err, servermanagement.NoBestServer) {
-		gameId = m.createAndWait(ctx)
-	} else if err != nil {
-		m.logger.Error("getting best server error", "error", err, "id", connId)
+	var queued time.Duration
+	waitInQueue := func() (string, error) {
+		start := time.Now()
+		defer func() { queued += time.Since(start) }()
+		return m.waitInQueue(ctx, connCtx, conn, game)
+	}
+
+	pick := func() (string, error) {
+		if m.queue.config.Enabled() && m.queue.waitingFor(game) > 0 {
+			// nobody gets to cut in front of the queue
+			return waitInQueue()
+		}
+
+		gameId, err := m.findServer(ctx, connCtx, game, 1)
+		logger.Info("getting best server", "game", game.String(), "gameId", gameId, "error", err)
+		if servermanagement.IsLimitReached(err) && m.queue.config.Enabled() {
+			return waitInQueue()
+		}
+		return gameId, err
+	}
+
+	var gameId string
+	var err error
+	if req.Party != "" {
+		gameId, err = m.waitForParty(ctx, connCtx, conn, req)
+	} else {
+		gameId, err = pick()
+	}
+
+	if err != nil {
+		logger.Error("getting best server error", "error", err)
 		return nil, err
 	}
 
-	gs, err := m.servers.GetConnectionString(gameId)
-	assert.NoError(err, "game server id somehow wasn't found", "id", connId)
-	assert.Assert(gs != "", "game server gameString did not produce a host:port pair", "id", gameId, "id", connId)
+	gs, err := m.connectionString(gameId)
+	for attempt := 1; err != nil; attempt++ {
+		// closed by the autoscaler or gone on its own since it was picked.
+		// A party is not split up over a retry, it fails together
+		logger.Warn("selected game server is gone", "gameId", gameId, "attempt", attempt, "error", err)
+		if req.Party != "" || attempt >= MATCHMAKE_ATTEMPTS {
+			return nil, fmt.Errorf("%w: %w", MatchmakingServerGone, err)
+		}
+
+		if gameId, err = pick(); err != nil {
+			logger.Error("getting best server error", "error", err)
+			return nil, err
+		}
+		gs, err = m.connectionString(gameId)
+	}
 
 	// TODO probably better to just get a full server information
-	m.logger.Info("game server selected", "host:port", gs, "id", connId)
+	logger.Info("game server selected", "host:port", gs)
 
     return &GameConnectionInfo{
         Id: gameId,
         Addr: gs,
+        Queued: queued,
     }, nil
 }
 
+// connectionString is the server's host:port, an error once the server is
+// closed or unknown
+func (m *MatchMakingServer) connectionString(gameId string) (string, error) {
+	gs, err := m.servers.GetConnectionString(gameId)
+	if err == nil && gs == "" {
+		err = fmt.Errorf("%w: %s has no host:port", servermanagement.UnknownServer, gameId)
+	}
+	return gs, err
+}
+
 func (m *MatchMakingServer) Close() {
 	m.logger.Warn("closing down")
     //... hmm
 }
 
+// connLogger tags every line with the connection and the client it is for
+func (m *MatchMakingServer) connLogger(conn AMConnection) *slog.Logger {
+	return m.logger.With("conn", conn.Id(), "client", conn.ClientId())
+}
+
 func NewMatchMakingServer(servers GameServer) *MatchMakingServer {
 	return &MatchMakingServer{
         servers: servers,
 		logger:           slog.Default().With("area", "MatchMakingServer"),
-		waitingForServer: false,
 		ready:            false,
-		mutex:            sync.Mutex{},
+		broker:           newCreationBroker(servers),
+		createWait:       newLatencyHistogram(),
+		queue:            newMatchQueue(QueueConfig{}),
+		parties:          newPartyHolder(DEFAULT_PARTY_TIMEOUT),
 	}
 }
 
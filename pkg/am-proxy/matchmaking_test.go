@@ -0,0 +1,70 @@
+package amproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	servermanagement "vim-arcade.theprimeagen.com/pkg/server-management"
+)
+
+// vanishingServers hands out best servers in order, the ones in gone are
+// closed by the time anyone asks where they are
+type vanishingServers struct {
+	GameServer
+
+	mutex sync.Mutex
+	best  []string
+	gone  map[string]bool
+}
+
+func (v *vanishingServers) GetBestServer(game gameserverstats.GameType, connections int) (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if len(v.best) == 0 {
+		return "", fmt.Errorf("out of servers")
+	}
+	id := v.best[0]
+	v.best = v.best[1:]
+	return id, nil
+}
+
+func (v *vanishingServers) GetConnectionString(id string) (string, error) {
+	if v.gone[id] {
+		return "", fmt.Errorf("%w: %s", servermanagement.ServerClosed, id)
+	}
+	return "127.0.0.1:" + id, nil
+}
+
+func matchOne(t *testing.T, servers *vanishingServers) (*GameConnectionInfo, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	conn, _ := acceptOne(t, l)
+
+	m := NewMatchMakingServer(servers)
+	return m.matchmake(context.Background(), context.Background(), conn, MatchRequest{Game: gameserverstats.DefaultGame})
+}
+
+func TestMatchmakeRetriesGoneServers(t *testing.T) {
+	info, err := matchOne(t, &vanishingServers{
+		best: []string{"1", "2", "3"},
+		gone: map[string]bool{"1": true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "2", info.Id)
+	require.Equal(t, "127.0.0.1:2", info.Addr)
+
+	// every pick closed, the connection fails instead of the proxy
+	_, err = matchOne(t, &vanishingServers{
+		best: []string{"1", "2", "3", "4"},
+		gone: map[string]bool{"1": true, "2": true, "3": true},
+	})
+	require.ErrorIs(t, err, MatchmakingServerGone)
+	require.ErrorIs(t, err, servermanagement.ServerClosed)
+}
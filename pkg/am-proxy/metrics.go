@@ -0,0 +1,220 @@
+package amproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+const METRICS_PREFIX = "vim_arcade_"
+
+// seconds, creating a game server is the only thing expected to go past 1s
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// latencyHistogram is the prometheus histogram without prometheus
+type latencyHistogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+
+	// not cumulative, that happens when written out
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		buckets: latencyBuckets,
+		counts:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.sum += seconds
+	h.count += 1
+	for i, le := range h.buckets {
+		if seconds <= le {
+			h.counts[i] += 1
+			break
+		}
+	}
+}
+
+func (h *latencyHistogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+type metricsWriter struct {
+	out io.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...any) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.out, format, args...)
+}
+
+func (m *metricsWriter) header(name string, kind string, help string) {
+	m.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", METRICS_PREFIX, name, help, METRICS_PREFIX, name, kind)
+}
+
+func (m *metricsWriter) value(name string, kind string, help string, value any) {
+	m.header(name, kind, help)
+	m.printf("%s%s %v\n", METRICS_PREFIX, name, value)
+}
+
+func (m *metricsWriter) histogram(name string, help string, h *latencyHistogram) {
+	h.mutex.Lock()
+	counts := append([]uint64{}, h.counts...)
+	sum := h.sum
+	count := h.count
+	h.mutex.Unlock()
+
+	m.header(name, "histogram", help)
+	var cumulative uint64 = 0
+	for i, le := range h.buckets {
+		cumulative += counts[i]
+		m.printf("%s%s_bucket{le=\"%s\"} %d\n", METRICS_PREFIX, name, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	m.printf("%s%s_bucket{le=\"+Inf\"} %d\n", METRICS_PREFIX, name, count)
+	m.printf("%s%s_sum %s\n", METRICS_PREFIX, name, strconv.FormatFloat(sum, 'g', -1, 64))
+	m.printf("%s%s_count %d\n", METRICS_PREFIX, name, count)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// AMMetricsServer serves the proxy's stats in the prometheus text format on
+// /metrics.  It is optional and has no say in the proxy's lifecycle
+type AMMetricsServer struct {
+	proxy  *AMProxy
+	stats  gameserverstats.GSSRetriever
+	port   uint16
+	logger *slog.Logger
+	ready  chan struct{}
+}
+
+func NewMetricsServer(proxy *AMProxy, stats gameserverstats.GSSRetriever, port uint16) AMMetricsServer {
+	return AMMetricsServer{
+		proxy:  proxy,
+		stats:  stats,
+		port:   port,
+		logger: slog.Default().With("area", "AMMetricsServer"),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (a *AMMetricsServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", a.serveMetrics)
+	return mux
+}
+
+func (a *AMMetricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := a.WriteMetrics(w); err != nil {
+		a.logger.Error("unable to write metrics", "error", err)
+	}
+}
+
+func (a *AMMetricsServer) WriteMetrics(out io.Writer) error {
+	m := &metricsWriter{out: out}
+	stats := a.proxy.Stats()
+
+	m.value("proxy_connections_active", "gauge", "Connections currently held by the proxy.", stats.ActiveConnections)
+	m.value("proxy_connections_total", "counter", "Connections accepted by the proxy.", stats.TotalConnections)
+	m.value("proxy_connection_errors_total", "counter", "Connections removed because of an error.", stats.Errors)
+	m.value("proxy_auth_timeouts_total", "counter", "Connections that did not authenticate in time.", stats.AuthTimeouts)
+	m.value("proxy_auth_failures_total", "counter", "Connections that failed authentication.", stats.AuthFailures)
+	m.value("proxy_heartbeat_timeouts_total", "counter", "Connections dropped for missing heartbeats.", stats.HeartbeatTimeouts)
+	m.value("proxy_matchmaking_failures_total", "counter", "Connections that could not be matched to a game server.", stats.MatchmakingFailures)
+
+	m.header("proxy_forwarded_packets_total", "counter", "Packets forwarded between clients and game servers.")
+	m.printf("%sproxy_forwarded_packets_total{direction=\"client_to_game\"} %d\n", METRICS_PREFIX, stats.ClientToGamePackets)
+	m.printf("%sproxy_forwarded_packets_total{direction=\"game_to_client\"} %d\n", METRICS_PREFIX, stats.GameToClientPackets)
+	m.header("proxy_forwarded_bytes_total", "counter", "Bytes forwarded between clients and game servers.")
+	m.printf("%sproxy_forwarded_bytes_total{direction=\"client_to_game\"} %d\n", METRICS_PREFIX, stats.ClientToGameBytes)
+	m.printf("%sproxy_forwarded_bytes_total{direction=\"game_to_client\"} %d\n", METRICS_PREFIX, stats.GameToClientBytes)
+
+	m.header("proxy_disallowed_total", "counter", "Connections refused by the connection limits.")
+	m.printf("%sproxy_disallowed_total{reason=\"rate\"} %d\n", METRICS_PREFIX, stats.RateLimited)
+	m.printf("%sproxy_disallowed_total{reason=\"address\"} %d\n", METRICS_PREFIX, stats.AddressLimited)
+	m.printf("%sproxy_disallowed_total{reason=\"capacity\"} %d\n", METRICS_PREFIX, stats.CapacityLimited)
+
+	m.value("proxy_matchmaking_queue_length", "gauge", "Connections waiting for a game server to free up.", stats.QueuedConnections)
+	m.value("proxy_parties_waiting", "gauge", "Parties waiting on the rest of their members.", stats.PartiesWaiting)
+	m.value("proxy_connections_parked", "gauge", "Connections holding a game server for a dropped client.", stats.ParkedConnections)
+	m.value("proxy_resumes_total", "counter", "Dropped clients put back on their game server.", stats.Resumes)
+
+	m.histogram("proxy_auth_seconds", "Time from accepting a connection to its auth being decided.", a.proxy.authLatency)
+	m.histogram("proxy_matchmake_seconds", "Time spent finding a game server for a connection.", a.proxy.matchmakeLatency)
+	m.histogram("proxy_server_creation_wait_seconds", "Time spent waiting on a new game server to be ready.", a.proxy.match.createWait)
+
+	if a.stats == nil {
+		return m.err
+	}
+
+	configs, err := a.stats.GetAllGameServerConfigs()
+	if err != nil {
+		return errors.Join(m.err, err)
+	}
+
+	m.value("game_servers", "gauge", "Game servers known to the proxy.", len(configs))
+	m.header("game_server_connections", "gauge", "Connections reported by each game server.")
+	for _, c := range configs {
+		m.printf("%sgame_server_connections{id=\"%s\",addr=\"%s\",game=\"%s\",state=\"%s\"} %d\n",
+			METRICS_PREFIX, label(c.Id), label(c.Addr()), label(c.Game().String()), gameserverstats.StateToString(c.State), c.Connections)
+	}
+
+	return m.err
+}
+
+func (a *AMMetricsServer) WaitForReady(ctx context.Context) {
+	select {
+	case <-a.ready:
+	case <-ctx.Done():
+	}
+}
+
+func (a *AMMetricsServer) Run(ctx context.Context) error {
+	addr := fmt.Sprintf("0.0.0.0:%d", a.port)
+	listener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: a.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	a.logger.Info("metrics listening", "host:port", addr)
+	a.ready <- struct{}{}
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -2,20 +2,59 @@ package amproxy
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"net"
+	"sync"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
 	"vim-arcade.theprimeagen.com/pkg/packet"
 )
 
+// connectionIds are the ids every AMConnection carries, see AMConnection.Id
+type connectionIds struct {
+	id string
+
+	clientIdM sync.Mutex
+	clientId  string
+}
+
+func (c *connectionIds) Id() string {
+	return c.id
+}
+
+func (c *connectionIds) ClientId() string {
+	c.clientIdM.Lock()
+	defer c.clientIdM.Unlock()
+	return c.clientId
+}
+
+func (c *connectionIds) SetClientId(id string) {
+	c.clientIdM.Lock()
+	defer c.clientIdM.Unlock()
+	c.clientId = id
+}
+
 type AMTCPConnection struct {
+	connectionIds
 	conn net.Conn
 
+	// the remote end, the client or the game server
 	connStr string
 }
 
+// newConnectionId is random rather than counted so ids stay unique across
+// proxy restarts and can be traced through every proxy's logs
+func newConnectionId() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	assert.NoError(err, "unable to read random bytes for a connection id")
+	return hex.EncodeToString(id)
+}
+
 func CreateTCPConnectionFrom(connString string) (AMConnection, error) {
 	conn, err := net.Dial("tcp", connString)
 	if err != nil {
@@ -23,11 +62,30 @@ func CreateTCPConnectionFrom(connString string) (AMConnection, error) {
 	}
 
 	return &AMTCPConnection{
-		conn:    conn,
-		connStr: connString,
+		connectionIds: connectionIds{id: newConnectionId()},
+		conn:          conn,
+		connStr:       connString,
 	}, nil
 }
 
+// CreateTLSConnectionFactory dials game servers over tls, checking their cert
+// against the host they were dialed by
+func CreateTLSConnectionFactory(config *tls.Config) ConnectionFactory {
+	return func(connString string) (AMConnection, error) {
+		dialer := tls.Dialer{Config: config}
+		conn, err := dialer.Dial("tcp", connString)
+		if err != nil {
+			return nil, err
+		}
+
+		return &AMTCPConnection{
+			connectionIds: connectionIds{id: newConnectionId()},
+			conn:          conn,
+			connStr:       connString,
+		}, nil
+	}
+}
+
 func (a *AMTCPConnection) Read(b []byte) (int, error) {
 	return a.conn.Read(b)
 }
@@ -44,10 +102,6 @@ func (a *AMTCPConnection) String() string {
 	return a.connStr
 }
 
-func (a *AMTCPConnection) Id() string {
-	return "AMTCPConnection DOES NOT HAVE ID YET...."
-}
-
 func (a *AMTCPConnection) Addr() string {
 	return a.connStr
 }
@@ -58,6 +112,9 @@ type AMTCPProxy struct {
 	logger   *slog.Logger
 	listener net.Listener
 	ready    chan struct{}
+
+	// nil listens in plain tcp
+	tls *tls.Config
 }
 
 func NewTCPProxy(proxy *AMProxy, port uint16) AMTCPProxy {
@@ -70,10 +127,17 @@ func NewTCPProxy(proxy *AMProxy, port uint16) AMTCPProxy {
 	}
 }
 
+// WithTLS has clients connect over tls, see tlsconfig.ServerConfig
+func (a *AMTCPProxy) WithTLS(config *tls.Config) *AMTCPProxy {
+	a.tls = config
+	return a
+}
+
 func NewConnection(conn net.Conn) AMConnection {
 	return &AMTCPConnection{
-		conn:    conn,
-		connStr: conn.LocalAddr().String(),
+		connectionIds: connectionIds{id: newConnectionId()},
+		conn:          conn,
+		connStr:       conn.RemoteAddr().String(),
 	}
 }
 
@@ -102,7 +166,10 @@ func (a *AMTCPProxy) Run(ctx context.Context) {
 	a.logger.Info("server starting", "host:port", portStr)
 	l, err := net.Listen("tcp4", portStr)
 	assert.NoError(err, "unable to create proxy connection")
-	a.logger.Info("server started", "host:port", portStr)
+	if a.tls != nil {
+		l = tls.NewListener(l, a.tls)
+	}
+	a.logger.Info("server started", "host:port", portStr, "tls", a.tls != nil)
 
 	a.listener = l
 
@@ -118,14 +185,16 @@ outer:
 		select {
 		case conn := <-ch:
 			go func() {
-                a.logger.Info("new net.tcp connection", "laddr", conn.LocalAddr(), "raddr", conn.RemoteAddr())
-				err := a.proxy.Add(NewConnection(conn))
+				amConn := NewConnection(conn)
+                a.logger.Info("new net.tcp connection", "conn", amConn.Id(), "laddr", conn.LocalAddr(), "raddr", conn.RemoteAddr())
+				err := a.proxy.Add(amConn)
                 if err != nil {
                     pkt := packet.CreateErrorPacket(err)
                     _, err = pkt.Into(conn)
                     if err != nil {
-                        a.logger.Error("unable to write error packet into connection", "err", err)
+                        a.logger.Error("unable to write error packet into connection", "conn", amConn.Id(), "err", err)
                     }
+                    conn.Close()
                 }
 			}()
 		case <-ctx.Done():
@@ -134,6 +203,17 @@ outer:
 	}
 }
 
+// Drain stops accepting connections and then drains the proxy, see
+// AMProxy.Drain
+func (a *AMTCPProxy) Drain(ctx context.Context) error {
+	a.logger.Warn("draining, no longer accepting connections")
+	if a.listener != nil {
+		a.listener.Close()
+	}
+
+	return a.proxy.Drain(ctx)
+}
+
 func (a *AMTCPProxy) Close() {
 	if a.listener != nil {
 		a.listener.Close()
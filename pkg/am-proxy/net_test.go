@@ -0,0 +1,41 @@
+package amproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func acceptOne(t *testing.T, l net.Listener) (AMConnection, net.Conn) {
+	client, err := net.Dial("tcp4", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return NewConnection(conn), client
+}
+
+func TestTCPConnectionIds(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	first, client := acceptOne(t, l)
+	second, _ := acceptOne(t, l)
+
+	require.NotEmpty(t, first.Id())
+	require.NotEqual(t, first.Id(), second.Id())
+
+	// the client's end, not the listener's
+	require.Equal(t, client.LocalAddr().String(), first.Addr())
+	require.NotEqual(t, l.Addr().String(), first.Addr())
+
+	require.Empty(t, first.ClientId())
+	first.SetClientId("69696969")
+	require.Equal(t, "69696969", first.ClientId())
+	require.Empty(t, second.ClientId())
+}
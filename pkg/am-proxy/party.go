@@ -0,0 +1,124 @@
+package amproxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+var MatchmakingPartyTimeout = fmt.Errorf("party did not fill up in time")
+var MatchmakingPartyMismatch = fmt.Errorf("party is for a different game or size")
+var MatchmakingPartyTooLarge = fmt.Errorf("party is larger than a game server")
+
+// how long the first member of a party waits for everyone else
+const DEFAULT_PARTY_TIMEOUT = time.Second * 30
+
+// party is a group of connections that play on the same game server.  The
+// first member decides the game and size, everyone else has to match
+type party struct {
+	id   string
+	game gameserverstats.GameType
+	size int
+
+	// when the party gives up on filling, set by the first member
+	deadline time.Time
+	members  int
+
+	// closed once gameId and err are set
+	done   chan struct{}
+	gameId string
+	err    error
+}
+
+func (p *party) wait() (string, error) {
+	<-p.done
+	return p.gameId, p.err
+}
+
+func (p *party) finish(gameId string, err error) {
+	p.gameId = gameId
+	p.err = err
+	close(p.done)
+}
+
+// partyHolder holds members until their party is complete.  A complete party
+// leaves the holder, so the same id can be used again for the next game
+type partyHolder struct {
+	mutex   sync.Mutex
+	timeout time.Duration
+	parties map[string]*party
+}
+
+func newPartyHolder(timeout time.Duration) *partyHolder {
+	return &partyHolder{
+		timeout: timeout,
+		parties: map[string]*party{},
+	}
+}
+
+func (h *partyHolder) length() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.parties)
+}
+
+// join reports if the caller completed the party, the one that completes it
+// finds the party its server
+func (h *partyHolder) join(id string, game gameserverstats.GameType, size int) (*party, bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	p, ok := h.parties[id]
+	if !ok {
+		p = &party{
+			id:       id,
+			game:     game,
+			size:     size,
+			deadline: time.Now().Add(h.timeout),
+			done:     make(chan struct{}),
+		}
+		h.parties[id] = p
+	} else if p.game != game || p.size != size {
+		return nil, false, fmt.Errorf("%w: %s is %d players of %s", MatchmakingPartyMismatch, id, p.size, p.game.String())
+	}
+
+	p.members++
+	if p.members < p.size {
+		return p, false, nil
+	}
+
+	delete(h.parties, id)
+	return p, true, nil
+}
+
+// leave is false when the party already completed, the server is found
+// with or without the member
+func (h *partyHolder) leave(p *party) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.parties[p.id] != p {
+		return false
+	}
+
+	p.members--
+	if p.members == 0 {
+		delete(h.parties, p.id)
+	}
+	return true
+}
+
+// expire fails everyone waiting on a party that never filled up
+func (h *partyHolder) expire(p *party) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.parties[p.id] != p {
+		return
+	}
+
+	delete(h.parties, p.id)
+	p.finish("", MatchmakingPartyTimeout)
+}
@@ -0,0 +1,59 @@
+package amproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+func TestPartyHolderCompletes(t *testing.T) {
+	holder := newPartyHolder(time.Minute)
+	game := gameserverstats.DefaultGame
+
+	first, complete, err := holder.join("party", game, 2)
+	require.NoError(t, err)
+	require.False(t, complete)
+
+	_, _, err = holder.join("party", gameserverstats.GameType{Name: "other", Version: 1}, 2)
+	require.ErrorIs(t, err, MatchmakingPartyMismatch)
+	_, _, err = holder.join("party", game, 3)
+	require.ErrorIs(t, err, MatchmakingPartyMismatch)
+
+	second, complete, err := holder.join("party", game, 2)
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Same(t, first, second)
+	require.Equal(t, 0, holder.length())
+
+	// too late to leave, the server is on its way
+	require.False(t, holder.leave(first))
+
+	// the id is free for the next game
+	next, complete, err := holder.join("party", game, 2)
+	require.NoError(t, err)
+	require.False(t, complete)
+	require.NotSame(t, first, next)
+}
+
+func TestPartyHolderLeaveAndExpire(t *testing.T) {
+	holder := newPartyHolder(time.Minute)
+	game := gameserverstats.DefaultGame
+
+	p, _, err := holder.join("party", game, 3)
+	require.NoError(t, err)
+	_, _, err = holder.join("party", game, 3)
+	require.NoError(t, err)
+
+	require.True(t, holder.leave(p))
+	require.Equal(t, 1, p.members)
+
+	holder.expire(p)
+	_, err = p.wait()
+	require.ErrorIs(t, err, MatchmakingPartyTimeout)
+	require.Equal(t, 0, holder.length())
+
+	// already gone, nothing to expire twice
+	holder.expire(p)
+}
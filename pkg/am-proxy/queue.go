@@ -0,0 +1,193 @@
+package amproxy
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+var MatchmakingQueueFull = fmt.Errorf("matchmaking queue is full, please try again later")
+var MatchmakingQueueTimeout = fmt.Errorf("timed out waiting for a game server")
+
+// how often the queue's head looks for a game server
+const QUEUE_POLL_INTERVAL = time.Millisecond * 100
+
+// QueueConfig is the wait queue used once no more game servers can be created
+type QueueConfig struct {
+	// 0 disables the queue, connections fail when no server can be created
+	Size int
+
+	// 0 waits forever
+	Timeout time.Duration
+}
+
+func (q QueueConfig) Enabled() bool {
+	return q.Size > 0
+}
+
+type queuedConnection struct {
+	conn AMConnection
+	game gameserverstats.GameType
+
+	// only ever holds the latest position
+	position     chan int
+	lastPosition int
+
+	// the game server id, written once by the queue
+	assigned chan string
+}
+
+func (q *queuedConnection) setPosition(position int) {
+	select {
+	case <-q.position:
+	default:
+	}
+	q.position <- position
+}
+
+// matchQueue is first in first out per game, the first connection waiting
+// on a game is the only one that can be assigned one of its servers.  Size is
+// shared by every game
+type matchQueue struct {
+	mutex   sync.Mutex
+	config  QueueConfig
+	waiting []*queuedConnection
+
+	// a single goroutine polls for servers while anyone is waiting
+	polling bool
+}
+
+func newMatchQueue(config QueueConfig) *matchQueue {
+	return &matchQueue{
+		config:  config,
+		waiting: []*queuedConnection{},
+	}
+}
+
+func (q *matchQueue) length() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.waiting)
+}
+
+func (q *matchQueue) waitingFor(game gameserverstats.GameType) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	count := 0
+	for _, w := range q.waiting {
+		if w.game == game {
+			count++
+		}
+	}
+	return count
+}
+
+// games are the distinct games waiting, in the order they were queued
+func (q *matchQueue) games() []gameserverstats.GameType {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	out := []gameserverstats.GameType{}
+	for _, w := range q.waiting {
+		if !slices.Contains(out, w.game) {
+			out = append(out, w.game)
+		}
+	}
+	return out
+}
+
+// enqueue reports if the caller has to start polling for the queue
+func (q *matchQueue) enqueue(conn AMConnection, game gameserverstats.GameType) (*queuedConnection, bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.waiting) >= q.config.Size {
+		return nil, false, MatchmakingQueueFull
+	}
+
+	queued := &queuedConnection{
+		conn:     conn,
+		game:     game,
+		position: make(chan int, 1),
+		assigned: make(chan string, 1),
+	}
+	q.waiting = append(q.waiting, queued)
+	q.updatePositions(game)
+
+	startPolling := !q.polling
+	q.polling = true
+	return queued, startPolling, nil
+}
+
+// remove is false when the connection already left the queue, which means
+// it has been assigned a game server
+func (q *matchQueue) remove(queued *queuedConnection) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	idx := slices.Index(q.waiting, queued)
+	if idx == -1 {
+		return false
+	}
+
+	q.waiting = slices.Delete(q.waiting, idx, idx+1)
+	q.updatePositions(queued.game)
+	return true
+}
+
+// assign gives the first connection waiting on the game the game server
+func (q *matchQueue) assign(game gameserverstats.GameType, gameId string) *queuedConnection {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	idx := slices.IndexFunc(q.waiting, func(w *queuedConnection) bool {
+		return w.game == game
+	})
+	if idx == -1 {
+		return nil
+	}
+
+	head := q.waiting[idx]
+	q.waiting = slices.Delete(q.waiting, idx, idx+1)
+	head.assigned <- gameId
+	q.updatePositions(game)
+	return head
+}
+
+// stopPolling is true when the queue is empty, otherwise the poller has to
+// keep going
+func (q *matchQueue) stopPolling() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.waiting) > 0 {
+		return false
+	}
+
+	q.polling = false
+	return true
+}
+
+// a position only counts the connections waiting on the same game.  Anyone
+// already at their position is left alone, so nobody hears it twice
+func (q *matchQueue) updatePositions(game gameserverstats.GameType) {
+	position := 0
+	for _, w := range q.waiting {
+		if w.game != game {
+			continue
+		}
+		position++
+		if w.lastPosition != position {
+			w.lastPosition = position
+			w.setPosition(position)
+		}
+	}
+}
+
+func queuePositionMessage(position int) string {
+	return fmt.Sprintf("queued: position %d", position)
+}
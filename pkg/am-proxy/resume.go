@@ -0,0 +1,131 @@
+package amproxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"vim-arcade.theprimeagen.com/pkg/assert"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+var AMProxyResumeInvalid = fmt.Errorf("unable to resume, the session is gone")
+
+const RESUME_TOKEN_SIZE = 16
+
+// resumedClient is a new client connection taking over a parked one
+type resumedClient struct {
+	conn   AMConnection
+	framer *packet.PacketFramer
+}
+
+// WithResumeGracePeriod keeps the game server side of a connection whose
+// client dropped for the grace period.  A client that comes back with its
+// resume token in time is put back on the same game server, 0 never resumes
+func (m *AMProxy) WithResumeGracePeriod(grace time.Duration) *AMProxy {
+	m.resumeGrace = grace
+	return m
+}
+
+// newResumeToken replaces the connection's token, a token is only ever good
+// for a single resume.  Empty when the proxy does not resume
+func (m *AMProxy) newResumeToken(w *AMConnectionWrapper) []byte {
+	if m.resumeGrace == 0 {
+		return nil
+	}
+
+	token := make([]byte, RESUME_TOKEN_SIZE)
+	_, err := rand.Read(token)
+	assert.NoError(err, "unable to read random bytes for a resume token")
+
+	m.connsM.Lock()
+	w.resumeToken = string(token)
+	m.connsM.Unlock()
+	return token
+}
+
+// park holds on to the game server side of a connection whose client went
+// away, false is a connection that cannot be resumed and has to be removed
+func (m *AMProxy) park(w *AMConnectionWrapper) bool {
+	if m.resumeGrace == 0 || m.IsDraining() || w.ctx.Err() != nil {
+		return false
+	}
+
+	m.connsM.Lock()
+	if w.resumeToken == "" {
+		m.connsM.Unlock()
+		return false
+	}
+	m.parked[w.resumeToken] = w
+	w.parked = true
+	m.connsM.Unlock()
+
+	w.client().Close()
+	w.logger.Warn("client dropped, holding its game server", "server-id", w.gsId, "grace", m.resumeGrace)
+	return true
+}
+
+// unpark is false when a resume already took the connection
+func (m *AMProxy) unpark(w *AMConnectionWrapper) bool {
+	m.connsM.Lock()
+	defer m.connsM.Unlock()
+
+	if m.parked[w.resumeToken] != w {
+		return false
+	}
+
+	delete(m.parked, w.resumeToken)
+	w.parked = false
+	return true
+}
+
+func (m *AMProxy) parkedConnections() int {
+	m.connsM.Lock()
+	defer m.connsM.Unlock()
+	return len(m.parked)
+}
+
+// resumeConnection hands the new connection's client to the parked connection
+// it dropped from.  The new wrapper is closed once the parked one has it, the
+// Authenticator is not asked again since the token is proof enough.  The two
+// trade limiter slots, the parked one holds the slot of the client it now has
+// and the new one gives back the slot of the client that dropped
+func (m *AMProxy) resumeConnection(nw *AMConnectionWrapper, pkt *packet.Packet) error {
+	id, token, err := packet.ParseClientResume(pkt)
+	if err != nil {
+		return errors.Join(AMProxyResumeInvalid, err)
+	}
+
+	// closing the new wrapper must leave the client alone
+	conn := nw.client()
+
+	m.connsM.Lock()
+	w, ok := m.parked[string(token)]
+	if !ok || !bytes.Equal(w.clientId, id) {
+		m.connsM.Unlock()
+		return AMProxyResumeInvalid
+	}
+	delete(m.parked, string(token))
+	w.parked = false
+	w.slotAddr, nw.slotAddr = nw.slotAddr, w.slotAddr
+	m.connsM.Unlock()
+
+	conn.SetClientId(hex.EncodeToString(id))
+	nw.setClient(nil)
+
+	select {
+	case w.resumed <- resumedClient{conn: conn, framer: nw.cFramer}:
+	case <-w.ctx.Done():
+		// the slots stay traded, each wrapper gives back one of them
+		nw.setClient(conn)
+		return AMProxyResumeInvalid
+	}
+
+	w.logger.Warn("client resumed", "server-id", w.gsId, "from", nw.id, "addr", conn.Addr())
+	m.stats.resumes.Add(1)
+	nw.Close()
+	return nil
+}
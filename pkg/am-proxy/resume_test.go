@@ -0,0 +1,79 @@
+package amproxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+// fromAddr is a connection from wherever the test says it is
+type fromAddr struct {
+	AMConnection
+	addr string
+}
+
+func (f *fromAddr) Addr() string {
+	return f.addr
+}
+
+func pipeFrom(t *testing.T, addr string) (AMConnection, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return &fromAddr{AMConnection: NewConnection(server), addr: addr}, client
+}
+
+func TestResumeMovesTheLimiterSlot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	proxy := NewAMProxy(ctx, nil, nil, nil)
+	proxy.WithResumeGracePeriod(time.Minute)
+	proxy.WithConnectionLimits(ConnectionLimits{MaxPerIP: 1})
+
+	// the client dropped from home and is parked, nobody is forwarding yet
+	dropped, _ := pipeFrom(t, "10.0.0.1:6969")
+	require.NoError(t, proxy.Add(dropped))
+
+	clientId := []byte("0123456789abcdef")
+	proxy.connsM.Lock()
+	parked := proxy.conns[dropped.Id()]
+	parked.clientId = clientId
+	parked.resumeToken = "resume-me"
+	parked.parked = true
+	proxy.parked[parked.resumeToken] = parked
+	proxy.connsM.Unlock()
+
+	resumed := make(chan resumedClient, 1)
+	go func() { resumed <- <-parked.resumed }()
+
+	// and comes back from somewhere else
+	conn, client := pipeFrom(t, "10.0.0.2:6969")
+	require.NoError(t, proxy.Add(conn))
+	pkt, err := packet.CreateClientResume(clientId, []byte("resume-me"))
+	require.NoError(t, err)
+	go pkt.Into(client)
+
+	got := <-resumed
+	require.Equal(t, conn.Id(), got.conn.Id())
+	require.Eventually(t, func() bool {
+		return proxy.Stats().ActiveConnections == 1
+	}, time.Second, time.Millisecond * 10)
+
+	// the client is on 10.0.0.2 now, home has its slot back
+	again, _ := pipeFrom(t, "10.0.0.2:420")
+	require.ErrorIs(t, proxy.Add(again), AMProxyDisallowed)
+	home, _ := pipeFrom(t, "10.0.0.1:420")
+	require.NoError(t, proxy.Add(home))
+
+	stats := proxy.Stats()
+	require.Equal(t, 1, stats.Resumes)
+	require.Equal(t, 1, stats.AddressLimited)
+	require.Equal(t, 2, stats.ActiveConnections)
+}
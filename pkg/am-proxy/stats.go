@@ -0,0 +1,125 @@
+package amproxy
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// AMProxyStats is a point in time copy of the proxy's counters, see
+// AMProxy.Stats
+type AMProxyStats struct {
+	ActiveConnections int
+	TotalConnections  int
+
+	// connections removed because of an error
+	Errors int
+
+	HeartbeatTimeouts   int
+	AuthTimeouts        int
+	AuthFailures        int
+	MatchmakingFailures int
+
+	// forwarded packets only, heartbeats and handshakes stay off the books
+	ClientToGameBytes   uint64
+	ClientToGamePackets uint64
+	GameToClientBytes   uint64
+	GameToClientPackets uint64
+
+	Limits          ConnectionLimits
+	TrackedIPs      int
+	RateLimited     int
+	AddressLimited  int
+	CapacityLimited int
+
+	// waiting in the matchmaking queue right now
+	QueuedConnections int
+
+	// parties still waiting on members right now
+	PartiesWaiting int
+
+	// connections holding a game server for a client that dropped, and the
+	// clients that came back
+	ParkedConnections int
+	Resumes           int
+}
+
+func (s *AMProxyStats) String() string {
+	return fmt.Sprintf(`AMProxyStats:
+connections: active=%d total=%d errors=%d
+timeouts: auth=%d heartbeat=%d
+failures: auth=%d matchmaking=%d
+client -> game: packets=%d bytes=%d
+game -> client: packets=%d bytes=%d
+limited: rate=%d address=%d capacity=%d tracked-ips=%d
+queued: %d parties-waiting: %d
+resume: parked=%d resumed=%d
+`,
+		s.ActiveConnections, s.TotalConnections, s.Errors,
+		s.AuthTimeouts, s.HeartbeatTimeouts,
+		s.AuthFailures, s.MatchmakingFailures,
+		s.ClientToGamePackets, s.ClientToGameBytes,
+		s.GameToClientPackets, s.GameToClientBytes,
+		s.RateLimited, s.AddressLimited, s.CapacityLimited, s.TrackedIPs,
+		s.QueuedConnections, s.PartiesWaiting,
+		s.ParkedConnections, s.Resumes)
+}
+
+// proxyStats is written to by every connection's goroutines, which is why it
+// is nothing but atomics
+type proxyStats struct {
+	activeConnections atomic.Int64
+	totalConnections  atomic.Int64
+	errors            atomic.Int64
+
+	heartbeatTimeouts   atomic.Int64
+	authTimeouts        atomic.Int64
+	authFailures        atomic.Int64
+	matchmakingFailures atomic.Int64
+
+	clientToGameBytes   atomic.Uint64
+	clientToGamePackets atomic.Uint64
+	gameToClientBytes   atomic.Uint64
+	gameToClientPackets atomic.Uint64
+
+	rateLimited     atomic.Int64
+	addressLimited  atomic.Int64
+	capacityLimited atomic.Int64
+
+	resumes atomic.Int64
+}
+
+func (p *proxyStats) clientToGame(n int) {
+	p.clientToGamePackets.Add(1)
+	p.clientToGameBytes.Add(uint64(n))
+}
+
+func (p *proxyStats) gameToClient(n int) {
+	p.gameToClientPackets.Add(1)
+	p.gameToClientBytes.Add(uint64(n))
+}
+
+// snapshot reads each counter on its own, so counters that move together
+// (bytes and packets) can be off by an in flight packet
+func (p *proxyStats) snapshot() AMProxyStats {
+	return AMProxyStats{
+		ActiveConnections: int(p.activeConnections.Load()),
+		TotalConnections:  int(p.totalConnections.Load()),
+		Errors:            int(p.errors.Load()),
+
+		HeartbeatTimeouts:   int(p.heartbeatTimeouts.Load()),
+		AuthTimeouts:        int(p.authTimeouts.Load()),
+		AuthFailures:        int(p.authFailures.Load()),
+		MatchmakingFailures: int(p.matchmakingFailures.Load()),
+
+		ClientToGameBytes:   p.clientToGameBytes.Load(),
+		ClientToGamePackets: p.clientToGamePackets.Load(),
+		GameToClientBytes:   p.gameToClientBytes.Load(),
+		GameToClientPackets: p.gameToClientPackets.Load(),
+
+		RateLimited:     int(p.rateLimited.Load()),
+		AddressLimited:  int(p.addressLimited.Load()),
+		CapacityLimited: int(p.capacityLimited.Load()),
+
+		Resumes: int(p.resumes.Load()),
+	}
+}
@@ -0,0 +1,127 @@
+package amproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"vim-arcade.theprimeagen.com/pkg/packet"
+	"vim-arcade.theprimeagen.com/pkg/websocket"
+)
+
+// AMWebSocketConnection is a browser client.  Binary messages carry the same
+// packet frames a tcp connection does, a frame can be split over messages
+type AMWebSocketConnection struct {
+	*websocket.Conn
+	connectionIds
+}
+
+func NewWebSocketConnection(conn *websocket.Conn) AMConnection {
+	return &AMWebSocketConnection{
+		Conn:          conn,
+		connectionIds: connectionIds{id: newConnectionId()},
+	}
+}
+
+func (a *AMWebSocketConnection) Addr() string {
+	return a.RemoteAddr()
+}
+
+func (a *AMWebSocketConnection) String() string {
+	return a.RemoteAddr()
+}
+
+// AMWebSocketProxy is the AMTCPProxy for clients that cannot open a socket.
+// Any path upgrades, the connections end up in the same AMProxy
+type AMWebSocketProxy struct {
+	port     uint16
+	proxy    *AMProxy
+	logger   *slog.Logger
+	listener net.Listener
+	ready    chan struct{}
+}
+
+func NewWebSocketProxy(proxy *AMProxy, port uint16) AMWebSocketProxy {
+	return AMWebSocketProxy{
+		port:   port,
+		proxy:  proxy,
+		logger: slog.Default().With("area", "AMWebSocketProxy"),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (a *AMWebSocketProxy) Handler() http.Handler {
+	return http.HandlerFunc(a.upgrade)
+}
+
+func (a *AMWebSocketProxy) upgrade(w http.ResponseWriter, r *http.Request) {
+	ws, err := websocket.Accept(w, r)
+	if err != nil {
+		a.logger.Warn("unable to upgrade", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+
+	conn := NewWebSocketConnection(ws)
+	a.logger.Info("new websocket connection", "conn", conn.Id(), "raddr", conn.Addr())
+
+	err = a.proxy.Add(conn)
+	if err != nil {
+		pkt := packet.CreateErrorPacket(err)
+		if _, err := pkt.Into(conn); err != nil {
+			a.logger.Error("unable to write error packet into connection", "conn", conn.Id(), "err", err)
+		}
+		conn.Close()
+	}
+}
+
+func (a *AMWebSocketProxy) WaitForReady(ctx context.Context) {
+	select {
+	case <-a.ready:
+		a.logger.Info("ready")
+	case <-ctx.Done():
+	}
+}
+
+// Run serves until ctx is done.  Closing the http server leaves upgraded
+// connections alone, they belong to the AMProxy
+func (a *AMWebSocketProxy) Run(ctx context.Context) error {
+	addr := fmt.Sprintf("0.0.0.0:%d", a.port)
+	listener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+	a.listener = listener
+
+	server := &http.Server{
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	a.logger.Info("websocket listening", "host:port", addr)
+	a.ready <- struct{}{}
+
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Drain stops accepting connections and then drains the proxy, see
+// AMProxy.Drain
+func (a *AMWebSocketProxy) Drain(ctx context.Context) error {
+	a.logger.Warn("draining, no longer accepting connections")
+	if a.listener != nil {
+		a.listener.Close()
+	}
+
+	return a.proxy.Drain(ctx)
+}
@@ -1,20 +1,39 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
 	"vim-arcade.theprimeagen.com/pkg/packet"
-	"vim-arcade.theprimeagen.com/pkg/utils"
+	"vim-arcade.theprimeagen.com/pkg/websocket"
 )
 
+var ClientAuthRejected = fmt.Errorf("authentication rejected")
+
+// ReconnectConfig is how a client that lost its connection gets back onto its
+// game server, see AMProxy.WithResumeGracePeriod
+type ReconnectConfig struct {
+	// 0 never reconnects
+	Attempts int
+
+	// the wait before the first attempt, doubled after every failed one up
+	// to MaxBackoff (0 keeps doubling)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
 type ClientState int
 
 const (
@@ -50,15 +69,46 @@ type Client struct {
 	logger   *slog.Logger
 	Host     string
 	Port     uint16
-	conn     net.Conn
+	conn     io.ReadWriteCloser
 	closed   bool
 	done     chan struct{}
 	ready    chan struct{}
 	mutex    sync.Mutex
 	State    ClientState
 	id       [16]byte
-	framer   packet.PacketFramer
+	framer   *packet.PacketFramer
 	ServerId string
+
+	// sent along with the id for the proxy's Authenticator
+	token []byte
+
+	// empty is whatever game the proxy defaults to
+	game        string
+	gameVersion uint16
+
+	// empty plays alone
+	party     string
+	partySize uint8
+
+	// what matchmaking said before the auth response, such as queue positions
+	Messages []string
+
+	// handed out by the proxy with every auth response, empty when the proxy
+	// does not resume
+	resumeToken []byte
+	reconnect   ReconnectConfig
+
+	// times the client got back onto its game server
+	Reconnects int
+
+	heartbeatConfig packet.HeartbeatConfig
+	heartbeat       *packet.Heartbeat
+
+	// empty connects over tcp
+	webSocketPath string
+
+	// nil connects without tls
+	tls *tls.Config
 }
 
 func (c *Client) String() string {
@@ -85,7 +135,6 @@ func NewClientFromConnString(hostAndPort string, id [16]byte) Client {
 		done:   make(chan struct{}, 1),
 		ready:  make(chan struct{}, 1),
 		closed: false,
-		framer: packet.NewPacketFramer(),
 	}
 }
 
@@ -100,7 +149,6 @@ func NewClient(host string, port uint16, id [16]byte) Client {
 		ready:  make(chan struct{}, 1),
 		id:     id,
 		closed: false,
-		framer: packet.NewPacketFramer(),
 	}
 }
 
@@ -109,7 +157,82 @@ func (d *Client) Id() string {
 }
 
 func (d *Client) Addr() string {
-	return fmt.Sprintf("%s:%d", d.Host, d.Port)
+	return net.JoinHostPort(d.Host, strconv.Itoa(int(d.Port)))
+}
+
+func (d *Client) WithToken(token []byte) *Client {
+	d.token = token
+	return d
+}
+
+// WithGame asks matchmaking for a server of the game and version
+func (d *Client) WithGame(name string, version uint16) *Client {
+	d.game = name
+	d.gameVersion = version
+	return d
+}
+
+// WithParty plays on the same game server as everyone else in the party.
+// Every member has to agree on the size, the proxy waits for all of them
+func (d *Client) WithParty(party string, size uint8) *Client {
+	d.party = party
+	d.partySize = size
+	return d
+}
+
+// WithReconnect resumes the connection with the proxy's token when it drops
+func (d *Client) WithReconnect(config ReconnectConfig) *Client {
+	d.reconnect = config
+	return d
+}
+
+// WithWebSocket connects to the proxy's websocket front end at the path
+// instead of over tcp, see AMWebSocketProxy
+func (d *Client) WithWebSocket(path string) *Client {
+	d.webSocketPath = path
+	return d
+}
+
+// WithTLS connects to a proxy listening with tls, see AMTCPProxy.WithTLS
+func (d *Client) WithTLS(config *tls.Config) *Client {
+	d.tls = config
+	return d
+}
+
+// dial is a tcp connection or a websocket to the proxy
+func (d *Client) dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	if d.webSocketPath != "" {
+		assert.Assert(d.tls == nil, "websockets are not served over tls")
+		return websocket.Dial(ctx, d.Addr(), d.webSocketPath)
+	}
+
+	if d.tls != nil {
+		dialer := tls.Dialer{Config: d.tls}
+		return dialer.DialContext(ctx, "tcp4", d.Addr())
+	}
+	return net.Dial("tcp4", d.Addr())
+}
+
+// WithHeartbeat pings the proxy once connected and disconnects when the proxy
+// misses too many pongs
+func (d *Client) WithHeartbeat(config packet.HeartbeatConfig) *Client {
+	d.heartbeatConfig = config
+	return d
+}
+
+// RTT is the round trip time to the proxy of the last heartbeat
+func (d *Client) RTT() time.Duration {
+	if d.heartbeat == nil {
+		return 0
+	}
+	return d.heartbeat.RTT()
+}
+
+func (d *Client) writePacket(pkt *packet.Packet) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	_, err := pkt.Into(d.conn)
+	return err
 }
 
 func (d *Client) Write(data []byte) error {
@@ -122,52 +245,229 @@ func (d *Client) Write(data []byte) error {
 func (d *Client) Connect(ctx context.Context) error {
 	d.State = CSConnecting
 	d.logger.Info("client connecting to match making")
-	connStr := fmt.Sprintf("%s:%d", d.Host, d.Port)
+	connStr := d.Addr()
 	d.logger.Info("connect to matchmaking", "conn", connStr)
-	conn, err := net.Dial("tcp4", connStr)
-	assert.NoError(err, "could not connect to server")
+	conn, err := d.dial(ctx)
+	if err != nil {
+		d.State = CSDisconnected
+		return err
+	}
 	d.logger.Info("connected to the match making server", "conn", connStr)
 
 	// TODO emit event?
 	d.State = CSAuthenticating
 
-	pkt := packet.CreateClientAuth(d.id[:])
+	pkt, err := packet.CreateClientAuthPacket(packet.ClientAuth{
+		Id:        d.id[:],
+		Game:      d.game,
+		Version:   d.gameVersion,
+		Party:     d.party,
+		PartySize: d.partySize,
+		Token:     d.token,
+	})
+	if err != nil {
+		d.State = CSDisconnected
+		conn.Close()
+		return err
+	}
+
+	if err := d.handshake(conn, &pkt); err != nil {
+		d.State = CSDisconnected
+		return err
+	}
+
+	d.State = CSConnected
+	d.heartbeat = packet.NewHeartbeat(d.heartbeatConfig)
+	d.ready <- struct{}{}
+
+	go d.run(ctx)
+
+	return nil
+}
 
+// handshake sends the first packet and waits for the proxy to answer.  The
+// connection is closed unless the proxy accepts
+func (d *Client) handshake(conn io.ReadWriteCloser, first *packet.Packet) error {
 	// TODO handle framer errors?
-	go packet.FrameWithReader(&d.framer, conn)
+	// a framer per connection, the last one's reader may still be finishing
+	framer := packet.NewPacketFramer()
+	d.framer = &framer
+	go packet.FrameWithReader(d.framer, conn)
+
+	first.Into(conn)
+
+	var rsp *packet.Packet
+	for {
+		var ok bool
+		rsp, ok = <-d.framer.C
+
+		if !ok {
+			err := <-d.framer.Err
+			if err == nil {
+				err = io.EOF
+			}
+			d.logger.Error("connection closed before auth response", "error", err)
+			conn.Close()
+			return err
+		}
 
-	pkt.Into(conn)
-	rsp, ok := <-d.framer.C
+		if rsp.Type() != packet.PacketMessage {
+			break
+		}
 
-	assert.Assert(ok, "expected channel to remain open")
-	assert.Assert(rsp != nil, "expected a packet")
-	assert.Assert(rsp.Type() == packet.PacketServerAuthResponse, "expected a auth response back")
+		msg := string(rsp.Data())
+		rsp.Release()
+		d.logger.Info("matchmaking message", "msg", msg)
+		d.Messages = append(d.Messages, msg)
+	}
 
-    ////////////// wait
-	assert.Assert(rsp.Data()[0] == 1, "should be authenticated")
+	if rsp.Type() == packet.PacketError {
+		err := fmt.Errorf("server error: %s", string(rsp.Data()))
+		rsp.Release()
+		conn.Close()
+		return err
+	}
+
+	assert.Assert(rsp.Type() == packet.PacketServerAuthResponse, "expected a auth response back")
 
 	d.logger.Info("auth response", "rsp", rsp)
+	auth, err := packet.ParseServerAuth(rsp)
+	auth.ResumeToken = bytes.Clone(auth.ResumeToken)
+	rsp.Release()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if !auth.Accepted {
+		conn.Close()
+		return errors.Join(ClientAuthRejected, fmt.Errorf("reason: %s", auth.Reason))
+	}
+
+	d.mutex.Lock()
 	d.conn = conn
-	d.ready <- struct{}{}
-    d.ServerId = packet.ServerAuthGameId(rsp)
+	d.mutex.Unlock()
+	d.ServerId = auth.GameId
+	d.resumeToken = auth.ResumeToken
+	return nil
+}
 
-	ctxReader := utils.NewContextReader(ctx)
-	go ctxReader.Read(conn)
+// resume puts the client back on its game server with a new connection
+func (d *Client) resume(ctx context.Context) error {
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	pkt, err := packet.CreateClientResume(d.id[:], d.resumeToken)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return d.handshake(conn, &pkt)
+}
+
+// reconnectToProxy is false once the client has given up, the proxy forgets a
+// client that takes longer than its grace period
+func (d *Client) reconnectToProxy(ctx context.Context) bool {
+	if d.reconnect.Attempts == 0 || len(d.resumeToken) == 0 {
+		return false
+	}
+
+	d.State = CSConnecting
+	backoff := d.reconnect.Backoff
+	for attempt := 1; attempt <= d.reconnect.Attempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		err := d.resume(ctx)
+		if err == nil {
+			d.logger.Warn("reconnected", "attempt", attempt, "server", d.ServerId)
+			d.Reconnects++
+			d.State = CSConnected
+			d.heartbeat.Stop()
+			d.heartbeat = packet.NewHeartbeat(d.heartbeatConfig)
+			return true
+		}
 
-	go func() {
-		for bytes := range ctxReader.Out {
-			d.logger.Error("message received", "data", string(bytes))
+		d.logger.Warn("unable to reconnect", "attempt", attempt, "error", err)
+		if errors.Is(err, ClientAuthRejected) {
+			return false
 		}
 
-		if err, ok := <-ctxReader.Err; ok && !d.closed {
-			d.logger.Error("error with client", "error", err)
+		backoff *= 2
+		if d.reconnect.MaxBackoff > 0 && backoff > d.reconnect.MaxBackoff {
+			backoff = d.reconnect.MaxBackoff
 		}
+	}
+
+	return false
+}
 
+func (d *Client) run(ctx context.Context) {
+	defer func() {
+		d.heartbeat.Stop()
 		d.State = CSDisconnected
 		d.done <- struct{}{}
 	}()
 
-	return nil
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.heartbeat.C():
+			ping, err := d.heartbeat.Beat()
+			if err != nil {
+				d.logger.Error("proxy missed heartbeats, disconnecting", "error", err)
+				d.conn.Close()
+
+				// the framer finishing is what reconnects
+				if d.reconnect.Attempts == 0 {
+					return
+				}
+				continue
+			}
+
+			if err = d.writePacket(&ping); err != nil {
+				d.logger.Error("unable to write ping", "error", err)
+			}
+		case pkt, ok := <-d.framer.C:
+			if !ok {
+				if err := <-d.framer.Err; err != nil && !d.closed {
+					d.logger.Error("error with client", "error", err)
+				}
+
+				if d.closed || !d.reconnectToProxy(ctx) {
+					return
+				}
+				continue
+			}
+
+			switch pkt.Type() {
+			case packet.PacketPing:
+				pong := packet.CreatePong(pkt)
+				if err := d.writePacket(&pong); err != nil {
+					d.logger.Error("unable to write pong", "error", err)
+				}
+			case packet.PacketPong:
+				rtt, err := d.heartbeat.Pong(pkt)
+				d.logger.Info("rtt", "rtt", rtt, "error", err)
+			case packet.PacketCloseConnection:
+				d.logger.Warn("server closed the connection")
+				pkt.Release()
+				d.closed = true
+				d.conn.Close()
+				return
+			default:
+				d.logger.Error("message received", "packet", pkt.String())
+			}
+			pkt.Release()
+		}
+	}
 }
 
 func (d *Client) WaitForDone() {
@@ -187,9 +487,9 @@ func (d *Client) Disconnect() {
 	assert.NotNil(d.conn, "attempting to disconnect a non connected client")
 
 	pkt := packet.CreateCloseConnection()
-	n, err := pkt.Into(d.conn)
+	err := d.writePacket(&pkt)
 	if err != nil {
-		d.logger.Error("unable to write ClientClose to source", "n", n, "err", err)
+		d.logger.Error("unable to write ClientClose to source", "err", err)
 	}
 
 	err = d.conn.Close()
@@ -197,3 +497,12 @@ func (d *Client) Disconnect() {
 		d.logger.Error("error on close during disconnect", "err", err)
 	}
 }
+
+// DropConnection closes the connection without telling the proxy, as if the
+// network went away
+func (d *Client) DropConnection() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	assert.NotNil(d.conn, "attempting to drop a non connected client")
+	return d.conn.Close()
+}
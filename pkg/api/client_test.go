@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+// silentProxy accepts the first auth and never answers a ping, every resume
+// after that is rejected
+func silentProxy(t *testing.T) (*net.TCPAddr, <-chan packet.PacketType) {
+    l, err := net.Listen("tcp4", "127.0.0.1:0")
+    require.NoError(t, err)
+    t.Cleanup(func() { l.Close() })
+
+    firsts := make(chan packet.PacketType, 10)
+    go func() {
+        accepted := false
+        for {
+            conn, err := l.Accept()
+            if err != nil {
+                return
+            }
+
+            framer := packet.NewPacketFramer()
+            go packet.FrameWithReader(&framer, conn)
+
+            first, ok := <-framer.C
+            if !ok {
+                conn.Close()
+                continue
+            }
+            firsts <- first.Type()
+
+            if accepted {
+                rsp := packet.CreateServerAuthResponse(false, "nope")
+                rsp.Into(conn)
+                conn.Close()
+                continue
+            }
+
+            accepted = true
+            rsp := packet.CreateServerAuthAccepted("game-server-69", []byte("resume-me"))
+            rsp.Into(conn)
+            go func() {
+                defer conn.Close()
+                for pkt := range framer.C {
+                    pkt.Release()
+                }
+            }()
+        }
+    }()
+
+    return l.Addr().(*net.TCPAddr), firsts
+}
+
+func TestClientMissedHeartbeatsWithReconnect(t *testing.T) {
+    addr, firsts := silentProxy(t)
+
+    client := NewClient(addr.IP.String(), uint16(addr.Port), [16]byte{0x69})
+    client.WithHeartbeat(packet.HeartbeatConfig{
+        Interval: time.Millisecond * 10,
+        MaxMissed: 2,
+    })
+    client.WithReconnect(ReconnectConfig{
+        Attempts: 3,
+        Backoff: time.Millisecond * 10,
+    })
+
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    require.NoError(t, client.Connect(ctx))
+    require.Equal(t, packet.PacketClientAuth, <-firsts)
+
+    // the dead connection is given up on and resumed, not written a ping
+    // it does not have
+    require.Equal(t, packet.PacketClientResume, <-firsts)
+
+    done := make(chan struct{})
+    go func() {
+        client.WaitForDone()
+        close(done)
+    }()
+
+    select {
+    case <-done:
+    case <-time.After(time.Second * 2):
+        require.FailNow(t, "client never gave up on the proxy")
+    }
+    require.Equal(t, CSDisconnected, client.State)
+    require.Equal(t, 0, client.Reconnects)
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -14,6 +18,25 @@ import (
 	"vim-arcade.theprimeagen.com/pkg/packet"
 )
 
+var GameServerPlayerUnknown = fmt.Errorf("expected the proxy to say who joined")
+var GameServerPlayerExpired = fmt.Errorf("player joined is too old")
+
+// the proxy says who joined right after connecting, anything older was held
+// on to and replayed
+const PLAYER_JOINED_MAX_AGE = time.Minute
+
+// PlayerSession is a player the proxy said joined, for as long as its
+// connection lasts
+type PlayerSession struct {
+    ClientId string
+    ConnId string
+    Addr string
+    Party string
+    PartySize int
+    Queued time.Duration
+    JoinedAt time.Time
+}
+
 var id = 0
 
 func getId() int {
@@ -30,6 +53,19 @@ type GameServerRunner struct {
 	listener net.Listener
 	logger   *slog.Logger
 	mutex    sync.Mutex
+
+	// nil when the proxy hop has no integrity
+	integrity *packet.Integrity
+	heartbeat packet.HeartbeatConfig
+
+	// verifies player joined packets, nil when the proxy has no secret
+	joinKey []byte
+
+	// by the server's own connection id, under mutex
+	sessions map[int]PlayerSession
+
+	// nil listens in plain tcp
+	tls *tls.Config
 }
 
 func NewGameServerRunner(db gameserverstats.GSSRetriever, stats gameserverstats.GameServerConfig) *GameServerRunner {
@@ -43,9 +79,41 @@ func NewGameServerRunner(db gameserverstats.GSSRetriever, stats gameserverstats.
         done: false,
 		doneChan:   make(chan struct{}, 1),
 		mutex:  sync.Mutex{},
+		sessions: map[int]PlayerSession{},
 	}
 }
 
+// WithIntegrity requires every frame from the proxy to carry the integrity
+// trailer and adds it to every frame sent back.  The key is derived from the
+// secret shared with the proxy and this server's id
+func (g *GameServerRunner) WithIntegrity(secret []byte, checksums bool) *GameServerRunner {
+    integrity := &packet.Integrity{Checksum: checksums}
+    if len(secret) > 0 {
+        integrity.Key = packet.DeriveKey(secret, g.stats.Id)
+    }
+
+    g.joinKey = integrity.Key
+    g.integrity = nil
+    if integrity.Enabled() {
+        g.integrity = integrity
+    }
+    return g
+}
+
+// WithHeartbeat pings the proxy on every connection and drops the connection
+// when the proxy misses too many pongs
+func (g *GameServerRunner) WithHeartbeat(config packet.HeartbeatConfig) *GameServerRunner {
+    g.heartbeat = config
+    return g
+}
+
+// WithTLS has the proxy connect over tls, with tlsconfig.ServerConfig's ca
+// file that is mutual tls
+func (g *GameServerRunner) WithTLS(config *tls.Config) *GameServerRunner {
+    g.tls = config
+    return g
+}
+
 func (g *GameServerRunner) innerListenForConnections(listener net.Listener) <-chan net.Conn {
 	ch := make(chan net.Conn, 10)
 	go func() {
@@ -69,7 +137,7 @@ func (g *GameServerRunner) incConnections(amount int) {
 	defer g.mutex.Unlock()
 
 	g.stats.Connections += amount
-	g.stats.Load += float32(amount) * 0.001
+	g.stats.Load += float32(amount) * gameserverstats.LOAD_PER_CONNECTION
 
 	if amount >= 0 {
 		g.stats.ConnectionsAdded += amount
@@ -81,20 +149,138 @@ func (g *GameServerRunner) incConnections(amount int) {
 
 }
 
+// join is the connection's first packet, the proxy saying who the player is
+func (g *GameServerRunner) join(id int, pkt *packet.Packet) error {
+    joined, err := packet.ParsePlayerJoined(pkt, g.joinKey)
+    if err != nil {
+        return errors.Join(GameServerPlayerUnknown, err)
+    }
+
+    if age := time.Since(joined.IssuedAt); age > PLAYER_JOINED_MAX_AGE {
+        return fmt.Errorf("%w: issued %s ago", GameServerPlayerExpired, age)
+    }
+
+    session := PlayerSession{
+        ClientId: hex.EncodeToString(joined.ClientId),
+        ConnId: joined.ConnId,
+        Addr: joined.Addr,
+        Party: joined.Party,
+        PartySize: int(joined.PartySize),
+        Queued: joined.Queued,
+        JoinedAt: time.Now(),
+    }
+
+    g.mutex.Lock()
+    g.sessions[id] = session
+    g.mutex.Unlock()
+
+    g.logger.Info("player joined", "id", id, "client", session.ClientId, "conn", session.ConnId, "addr", session.Addr, "party", session.Party, "queued", session.Queued)
+    return nil
+}
+
+func (g *GameServerRunner) leave(id int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+    delete(g.sessions, id)
+}
+
+// Sessions are the players connected at the time of calling, oldest first
+func (g *GameServerRunner) Sessions() []PlayerSession {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+    out := make([]PlayerSession, 0, len(g.sessions))
+    for _, s := range g.sessions {
+        out = append(out, s)
+    }
+
+    slices.SortFunc(out, func(a, b PlayerSession) int {
+        return a.JoinedAt.Compare(b.JoinedAt)
+    })
+    return out
+}
+
 func (g *GameServerRunner) handleConnection(ctx context.Context, conn net.Conn, id int) {
 	g.incConnections(1)
     defer g.incConnections(-1)
+    defer conn.Close()
 
     framer := packet.NewPacketFramer()
+    if g.integrity != nil {
+        framer.SetIntegrity(*g.integrity)
+    }
     go packet.FrameWithReader(&framer, conn)
 
+    heartbeat := packet.NewHeartbeat(g.heartbeat)
+    defer heartbeat.Stop()
+
+    joined := false
+
     for {
         select {
         case <-ctx.Done():
             return
-        case pkt := <-framer.C:
+        case <-heartbeat.C():
+            ping, err := heartbeat.Beat()
+            if err != nil {
+                g.logger.Warn("proxy missed heartbeats, closing connection", "id", id, "error", err)
+                return
+            }
+
+            if _, err = ping.IntoWithIntegrity(conn, g.integrity); err != nil {
+                g.logger.Error("unable to write ping", "id", id, "error", err)
+                return
+            }
+        case pkt, ok := <-framer.C:
+            if !ok {
+                err := <-framer.Err
+                if packet.IsStreamCorrupt(err) {
+                    g.logger.Error("corrupt packet stream, closing connection", "error", err)
+                    errPkt := packet.CreateErrorPacket(err)
+                    _, _ = errPkt.IntoWithIntegrity(conn, g.integrity)
+                } else {
+                    g.logger.Info("connection closed without close command", "error", err)
+                }
+                return
+            }
+
+            if !joined {
+                err := g.join(id, pkt)
+                pkt.Release()
+                if err != nil {
+                    g.logger.Warn("refusing connection", "id", id, "error", err)
+                    errPkt := packet.CreateErrorPacket(err)
+                    _, _ = errPkt.IntoWithIntegrity(conn, g.integrity)
+                    return
+                }
+
+                joined = true
+                defer g.leave(id)
+                continue
+            }
+
+            if packet.IsPing(pkt) {
+                pong := packet.CreatePong(pkt)
+                pkt.Release()
+                if _, err := pong.IntoWithIntegrity(conn, g.integrity); err != nil {
+                    g.logger.Error("unable to write pong", "id", id, "error", err)
+                    return
+                }
+                continue
+            }
+
+            if packet.IsPong(pkt) {
+                rtt, err := heartbeat.Pong(pkt)
+                pkt.Release()
+                g.logger.Info("rtt", "id", id, "rtt", rtt, "error", err)
+                continue
+            }
+
             g.logger.Info("packet received", "packet", pkt.String())
-            if packet.IsCloseConnection(pkt) {
+            closed := packet.IsCloseConnection(pkt)
+            pkt.Release()
+
+            if closed {
                 g.logger.Info("client sent close command")
                 return
             }
@@ -110,6 +296,9 @@ func (g *GameServerRunner) Run(outerCtx context.Context) error {
 	portStr := fmt.Sprintf(":%d", g.stats.Port)
 	listener, err := net.Listen("tcp4", portStr)
     assert.NoError(err, "unable to start server")
+    if g.tls != nil {
+        listener = tls.NewListener(listener, g.tls)
+    }
 
 	defer func() {
         g.done = true
@@ -217,7 +406,7 @@ func (g *GameServerRunner) ready() {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-    g.stats.State = gameserverstats.GSStateIdle
+    g.stats.State = gameserverstats.GSStateReady
     g.db.Update(g.stats)
     g.logger.Info("setting state to ready", "stats", g.stats)
 }
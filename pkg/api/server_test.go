@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+var testSecret = []byte("hunter2")
+
+type proxySide struct {
+    conn net.Conn
+    framer packet.PacketFramer
+    integrity *packet.Integrity
+}
+
+func runGameServer(t *testing.T) (*GameServerRunner, int) {
+    port, err := GetFreePort()
+    require.NoError(t, err)
+
+    db := gameserverstats.NewMemory()
+    server := NewGameServerRunner(db, gameserverstats.GameServerConfig{
+        Id: "test-server",
+        Host: "127.0.0.1",
+        Port: port,
+    })
+    server.WithIntegrity(testSecret, false)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    t.Cleanup(cancel)
+    go server.Run(ctx)
+
+    require.Eventually(t, func() bool {
+        return db.GetById("test-server") != nil
+    }, time.Second, 10 * time.Millisecond)
+    return server, port
+}
+
+func connectAsProxy(t *testing.T, port int) *proxySide {
+    conn, err := net.Dial("tcp4", fmt.Sprintf("127.0.0.1:%d", port))
+    require.NoError(t, err)
+    t.Cleanup(func() { conn.Close() })
+
+    integrity := &packet.Integrity{Key: packet.DeriveKey(testSecret, "test-server")}
+    p := &proxySide{conn: conn, framer: packet.NewPacketFramer(), integrity: integrity}
+    p.framer.SetIntegrity(*integrity)
+    go packet.FrameWithReader(&p.framer, conn)
+    return p
+}
+
+func (p *proxySide) send(t *testing.T, pkt packet.Packet) {
+    _, err := pkt.IntoWithIntegrity(p.conn, p.integrity)
+    require.NoError(t, err)
+}
+
+func (p *proxySide) join(t *testing.T, joined packet.PlayerJoined) {
+    pkt, err := packet.CreatePlayerJoined(joined, p.integrity.Key)
+    require.NoError(t, err)
+    p.send(t, pkt)
+}
+
+// refused reads past the heartbeats to the error the server closed with
+func (p *proxySide) refused(t *testing.T) string {
+    for pkt := range p.framer.C {
+        if pkt.Type() == packet.PacketError {
+            return string(pkt.Data())
+        }
+    }
+    require.FailNow(t, "connection closed without an error")
+    return ""
+}
+
+func TestGameServerSessions(t *testing.T) {
+    server, port := runGameServer(t)
+
+    clientId := [16]byte{}
+    copy(clientId[:], "player-one-here!")
+    proxy := connectAsProxy(t, port)
+    proxy.join(t, packet.PlayerJoined{
+        ClientId: clientId[:],
+        ConnId: "deadbeef",
+        Addr: "10.0.0.1:42069",
+        Party: "the-boys",
+        PartySize: 2,
+        Queued: time.Second,
+        IssuedAt: time.Now(),
+    })
+
+    require.Eventually(t, func() bool {
+        return len(server.Sessions()) == 1
+    }, time.Second, 10 * time.Millisecond)
+
+    session := server.Sessions()[0]
+    require.Equal(t, "706c617965722d6f6e652d6865726521", session.ClientId)
+    require.Equal(t, "deadbeef", session.ConnId)
+    require.Equal(t, "10.0.0.1:42069", session.Addr)
+    require.Equal(t, "the-boys", session.Party)
+    require.Equal(t, 2, session.PartySize)
+    require.Equal(t, time.Second, session.Queued)
+
+    // the session lasts as long as the connection
+    proxy.send(t, packet.CreateCloseConnection())
+    require.Eventually(t, func() bool {
+        return len(server.Sessions()) == 0
+    }, time.Second, 10 * time.Millisecond)
+}
+
+func TestGameServerRefusesUnknownPlayers(t *testing.T) {
+    server, port := runGameServer(t)
+
+    // anything before the player joined
+    proxy := connectAsProxy(t, port)
+    proxy.send(t, packet.CreateCloseConnection())
+    require.Contains(t, proxy.refused(t), GameServerPlayerUnknown.Error())
+
+    // a type nobody has given a name to
+    proxy = connectAsProxy(t, port)
+    unknown, err := packet.PacketFromParts(62, packet.EncodingBytes, []byte("hello?"))
+    require.NoError(t, err)
+    proxy.send(t, unknown)
+    require.Contains(t, proxy.refused(t), "unknown(62)")
+
+    // signed for another server
+    proxy = connectAsProxy(t, port)
+    pkt, err := packet.CreatePlayerJoined(packet.PlayerJoined{
+        ClientId: make([]byte, 16),
+        IssuedAt: time.Now(),
+    }, packet.DeriveKey(testSecret, "other-server"))
+    require.NoError(t, err)
+    proxy.send(t, pkt)
+    require.Contains(t, proxy.refused(t), packet.PacketSignatureMismatch.Error())
+
+    // held on to and replayed
+    proxy = connectAsProxy(t, port)
+    proxy.join(t, packet.PlayerJoined{
+        ClientId: make([]byte, 16),
+        IssuedAt: time.Now().Add(-PLAYER_JOINED_MAX_AGE * 2),
+    })
+    require.Contains(t, proxy.refused(t), GameServerPlayerExpired.Error())
+
+    require.Empty(t, server.Sessions())
+}
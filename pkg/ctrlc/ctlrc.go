@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -22,4 +23,34 @@ func HandleCtrlC(cancel context.CancelFunc) {
     }()
 }
 
+// HandleCtrlCWithShutdown runs shutdown (a drain) before cancelling.  A second
+// ctrl-c stops waiting on shutdown
+func HandleCtrlCWithShutdown(cancel context.CancelFunc, shutdown func() error) {
+    c := make(chan os.Signal, 2)
+    signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-c
+        slog.Warn("ctrl-c, shutting down", "area", "ctrlc")
+
+        done := make(chan error, 1)
+        go func() {
+            done <- shutdown()
+        }()
+
+        code := 0
+        select {
+        case err := <-done:
+            if err != nil {
+                slog.Error("shutdown finished with an error", "area", "ctrlc", "error", err)
+                code = 1
+            }
+        case <-c:
+            slog.Error("ctrl-c again, not waiting on shutdown", "area", "ctrlc")
+            code = 1
+        }
 
+        cancel()
+        time.Sleep(time.Millisecond * 250)
+        os.Exit(code)
+    }()
+}
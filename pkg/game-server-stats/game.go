@@ -0,0 +1,47 @@
+package gameserverstats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the game a client gets when it does not ask for one
+const DEFAULT_GAME_NAME = "dummy"
+const DEFAULT_GAME_VERSION = 1
+
+// GameType is a game and the version of it a server hosts.  A client only
+// ever plays on a server with the same name and version
+type GameType struct {
+	Name    string
+	Version uint16
+}
+
+var DefaultGame = GameType{Name: DEFAULT_GAME_NAME, Version: DEFAULT_GAME_VERSION}
+
+func (g GameType) String() string {
+	return fmt.Sprintf("%s@%d", g.Name, g.Version)
+}
+
+// OrDefault fills in the default game for a client that did not pick one
+func (g GameType) OrDefault() GameType {
+	if g.Name == "" {
+		return DefaultGame
+	}
+	return g
+}
+
+// ParseGameType is the inverse of String
+func ParseGameType(s string) (GameType, error) {
+	name, versionStr, ok := strings.Cut(s, "@")
+	if !ok || name == "" {
+		return GameType{}, fmt.Errorf("game type should be name@version: %q", s)
+	}
+
+	version, err := strconv.ParseUint(versionStr, 10, 16)
+	if err != nil {
+		return GameType{}, fmt.Errorf("game type has an invalid version: %q: %w", s, err)
+	}
+
+	return GameType{Name: name, Version: uint16(version)}, nil
+}
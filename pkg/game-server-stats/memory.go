@@ -0,0 +1,100 @@
+package gameserverstats
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Memory is a GSSRetriever without a database, everything is gone once the
+// process exits.  Servers that are only ever local to one process (tests)
+// have no need for sqlite
+type Memory struct {
+	mutex   sync.Mutex
+	configs map[string]GameServerConfig
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		configs: map[string]GameServerConfig{},
+	}
+}
+
+func (m *Memory) GetById(id string) *GameServerConfig {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	config, ok := m.configs[id]
+	if !ok {
+		return nil
+	}
+	return &config
+}
+
+// GetAllGameServerConfigs are ordered by id
+func (m *Memory) GetAllGameServerConfigs() ([]GameServerConfig, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out := make([]GameServerConfig, 0, len(m.configs))
+	for _, c := range m.configs {
+		out = append(out, c)
+	}
+
+	slices.SortFunc(out, func(a, b GameServerConfig) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return out, nil
+}
+
+func (m *Memory) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+// GetServersByUtilization matches the sqlite query, most loaded first.  Ties
+// go by id so the order never changes between calls
+func (m *Memory) GetServersByUtilization(game GameType, maxLoad float64) []GameServerConfig {
+	all, _ := m.GetAllGameServerConfigs()
+
+	out := []GameServerConfig{}
+	for _, c := range all {
+		if c.Game() == game && float64(c.Load) < maxLoad && c.State == GSStateReady {
+			out = append(out, c)
+		}
+	}
+
+	slices.SortStableFunc(out, func(a, b GameServerConfig) int {
+		return cmp.Compare(b.Load, a.Load)
+	})
+	return out
+}
+
+func (m *Memory) Update(stats GameServerConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats.LastUpdateMS = time.Now().UnixMilli()
+	m.configs[stats.Id] = stats
+	return nil
+}
+
+func (m *Memory) GetServerCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.configs)
+}
+
+func (m *Memory) GetTotalConnectionCount() GameServecConfigConnectionStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var counts GameServecConfigConnectionStats
+	for _, c := range m.configs {
+		counts.Connections += c.Connections
+		counts.ConnectionsAdded += c.ConnectionsAdded
+		counts.ConnectionsRemoved += c.ConnectionsRemoved
+	}
+	return counts
+}
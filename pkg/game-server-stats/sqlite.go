@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/tursodatabase/go-libsql"
@@ -36,6 +37,8 @@ func (s *Sqlite) CreateGameServerConfigs() error {
     query := `
     CREATE TABLE GameServerConfigs (
         id TEXT PRIMARY KEY,
+        game_type TEXT,
+        game_version INTEGER,
         state TEXT,
         connections INTEGER,
         connections_added INTEGER,
@@ -43,7 +46,8 @@ func (s *Sqlite) CreateGameServerConfigs() error {
         last_updated INTERGER,
         load REAL,
         host TEXT,
-        port INTEGER
+        port INTEGER,
+        region TEXT
     );`
 
     _, err := s.db.Exec(query)
@@ -51,7 +55,7 @@ func (s *Sqlite) CreateGameServerConfigs() error {
         return err
     }
 
-    var createLoadIndex = `CREATE INDEX idx_load ON GameServerConfigs (Load);`
+    var createLoadIndex = `CREATE INDEX idx_load ON GameServerConfigs (game_type, game_version, Load);`
     _, err = s.db.Exec(createLoadIndex)
 
     return err
@@ -130,11 +134,11 @@ FROM GameServerConfigs;`
 
 func (s *Sqlite) Update(stat GameServerConfig) error {
     s.logger.Info("Updating", "stat", stat)
-    query := `INSERT OR REPLACE INTO GameServerConfigs (id, state, connections, connections_added, connections_removed, load, host, port, last_updated)
-VALUES (?, ?, ?, ?, ?, ?, ?, ?, strftime('%s', 'now'));`
+    query := `INSERT OR REPLACE INTO GameServerConfigs (id, game_type, game_version, state, connections, connections_added, connections_removed, load, host, port, region, last_updated)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 
     // TODO probably don't need to update every
-    res, err := s.db.Exec(query, stat.Id, stat.State, stat.Connections, stat.ConnectionsAdded, stat.ConnectionsRemoved, stat.Load, stat.Host, stat.Port)
+    res, err := s.db.Exec(query, stat.Id, stat.GameType, stat.GameVersion, stat.State, stat.Connections, stat.ConnectionsAdded, stat.ConnectionsRemoved, stat.Load, stat.Host, stat.Port, stat.Region, time.Now().UnixMilli())
     n, err := res.RowsAffected()
     s.logger.Info("update complete", "rows affected", n, "error", err)
 
@@ -180,7 +184,7 @@ func (s *Sqlite) DeleteGameServerConfig(id string) {
 
 func (s *Sqlite) GetAllGameServerConfigs() ([]GameServerConfig, error) {
     var configs []GameServerConfig
-    query := `SELECT id, state, connections, load, host, port FROM GameServerConfigs;`
+    query := `SELECT id, game_type, game_version, state, connections, connections_added, connections_removed, last_updated, load, host, port, region FROM GameServerConfigs;`
 
     err := s.db.Select(&configs, query)
     if err != nil {
@@ -202,12 +206,12 @@ WHERE id=?;`, id)
     return nil
 }
 
-func (s *Sqlite) GetServersByUtilization(maxLoad float64) []GameServerConfig {
+func (s *Sqlite) GetServersByUtilization(game GameType, maxLoad float64) []GameServerConfig {
     var g []GameServerConfig
     s.db.Select(&g, `SELECT *
 FROM GameServerConfigs
-WHERE load < ? AND state == ?
-ORDER BY load DESC;`, maxLoad, GSStateReady)
-    s.logger.Info("GetServersByUtilization", "maxLoad", maxLoad, "count", len(g))
+WHERE game_type = ? AND game_version = ? AND load < ? AND state == ?
+ORDER BY load DESC;`, game.Name, game.Version, maxLoad, GSStateReady)
+    s.logger.Info("GetServersByUtilization", "game", game.String(), "maxLoad", maxLoad, "count", len(g))
     return g
 }
@@ -5,6 +5,9 @@ import (
 	"fmt"
 )
 
+// every connection adds this much to a game server's load
+const LOAD_PER_CONNECTION = 0.001
+
 type State int
 
 const (
@@ -35,6 +38,10 @@ type GameServerConfig struct {
 
 	Id string `db:"id"`
 
+	// see GameType, a server hosts exactly one
+	GameType    string `db:"game_type"`
+	GameVersion uint16 `db:"game_version"`
+
 	Connections        int `db:"connections"`
 	ConnectionsAdded   int `db:"connections_added"`
 	ConnectionsRemoved int `db:"connections_removed"`
@@ -46,9 +53,16 @@ type GameServerConfig struct {
 
 	Host string `db:"host"`
 
+	// where the server runs, empty when it does not say
+	Region string `db:"region"`
+
 	Port int `db:"port"`
 }
 
+func (g *GameServerConfig) Game() GameType {
+	return GameType{Name: g.GameType, Version: g.GameVersion}
+}
+
 func (g *GameServerConfig) Equal(other *GameServerConfig) bool {
     return g.Id == other.Id &&
         g.Connections == other.Connections &&
@@ -56,7 +70,7 @@ func (g *GameServerConfig) Equal(other *GameServerConfig) bool {
         g.ConnectionsRemoved == other.ConnectionsRemoved
 }
 
-func stateToString(state State) string {
+func StateToString(state State) string {
 	switch state {
 	case GSStateInitializing:
 		return "init"
@@ -72,7 +86,7 @@ func stateToString(state State) string {
 }
 
 func (g *GameServerConfig) String() string {
-	return fmt.Sprintf("Server(%s): Addr=%s Conns=%d Load=%f State=%s", g.Id, g.Addr(), g.Connections, g.Load, stateToString(g.State))
+	return fmt.Sprintf("Server(%s): Game=%s Addr=%s Conns=%d Load=%f State=%s", g.Id, g.Game().String(), g.Addr(), g.Connections, g.Load, StateToString(g.State))
 }
 
 func (g *GameServerConfig) Addr() string {
@@ -84,7 +98,7 @@ type GSSRetriever interface {
 	GetById(string) *GameServerConfig
 	GetAllGameServerConfigs() ([]GameServerConfig, error)
 	Run(ctx context.Context)
-	GetServersByUtilization(maxLoad float64) []GameServerConfig
+	GetServersByUtilization(game GameType, maxLoad float64) []GameServerConfig
 	Update(stats GameServerConfig) error
 	GetServerCount() int
 	GetTotalConnectionCount() GameServecConfigConnectionStats
@@ -0,0 +1,348 @@
+package packet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+
+	prettylog "vim-arcade.theprimeagen.com/pkg/pretty-log"
+	"vim-arcade.theprimeagen.com/pkg/utils"
+)
+
+// the ring has to be able to hold the largest single frame
+const FRAME_MAX_SIZE = HEADER_V2_SIZE + PACKET_V2_FRAME_PAYLOAD_SIZE
+const FRAMER_BUFFER_SIZE = FRAME_MAX_SIZE + MAX_TRAILER_SIZE
+
+var PacketFrameSizeExceeded = fmt.Errorf("Packet frame has exceeded allowed size of %d, larger packets must be fragmented", PACKET_V2_FRAME_PAYLOAD_SIZE)
+var PacketStreamCorrupt = fmt.Errorf("Packet stream is corrupt")
+
+// framed packets come out of one of two size classes.  Everything else
+// (reassembled fragments) is allocated
+var smallPackets = sync.Pool{
+    New: func() any {
+        return &Packet{data: make([]byte, PACKET_MAX_SIZE, PACKET_MAX_SIZE)}
+    },
+}
+
+var framePackets = sync.Pool{
+    New: func() any {
+        return &Packet{data: make([]byte, FRAME_MAX_SIZE, FRAME_MAX_SIZE)}
+    },
+}
+
+func acquirePacket(size int) *Packet {
+    var pool *sync.Pool
+    if size <= PACKET_MAX_SIZE {
+        pool = &smallPackets
+    } else if size <= FRAME_MAX_SIZE {
+        pool = &framePackets
+    } else {
+        return &Packet{data: make([]byte, size, size), len: size}
+    }
+
+    pkt := pool.Get().(*Packet)
+    pkt.pool = pool
+    pkt.len = size
+    return pkt
+}
+
+// Release hands a packet received from a PacketFramer back to the pool.  The
+// packet, and any slice taken from Data, cannot be used after release.
+// Releasing a packet that did not come from a framer does nothing
+func (p *Packet) Release() {
+    if p.pool == nil {
+        return
+    }
+
+    pool := p.pool
+    p.pool = nil
+    p.len = 0
+    pool.Put(p)
+}
+
+type PacketFramer struct {
+    // ring buffer of received bytes, head is the first unframed byte
+    buf []byte
+    head int
+    size int
+
+    header [HEADER_V2_SIZE]byte
+    trailer [MAX_TRAILER_SIZE]byte
+    verifier *frameVerifier
+
+    // v2 fragments are reassembled here until the final frame arrives
+    fragments []byte
+    fragmentTypeEnc byte
+    fragmenting bool
+
+    version uint8
+    C chan *Packet
+
+    // FrameWithReader closes C once the reader is finished.  If it finished
+    // with anything other than EOF the error is sent on Err before C closes
+    Err chan error
+}
+
+func NewPacketFramer() PacketFramer {
+    return PacketFramer{
+        buf: make([]byte, FRAMER_BUFFER_SIZE, FRAMER_BUFFER_SIZE),
+        version: VERSION,
+        verifier: newFrameVerifier(Integrity{}),
+        C: make(chan *Packet, 10),
+        Err: make(chan error, 1),
+    }
+}
+
+// SetIntegrity requires every frame to carry the integrity trailer.  This
+// excludes v1 frames as they cannot carry a trailer
+func (p *PacketFramer) SetIntegrity(integrity Integrity) {
+    p.verifier = newFrameVerifier(integrity)
+}
+
+// IsStreamCorrupt is true when the framer stopped because the peer sent bytes
+// that are not packets, as opposed to the connection going away
+func IsStreamCorrupt(err error) bool {
+    return errors.Is(err, PacketStreamCorrupt)
+}
+
+// PeerVersion is the version of the last frame received.  A peer that speaks
+// v2 can be answered with v2 packets, otherwise stick with v1
+func (p *PacketFramer) PeerVersion() uint8 {
+    return p.version
+}
+
+func (p *PacketFramer) tail() int {
+    return (p.head + p.size) % len(p.buf)
+}
+
+// writable is the contiguous free space of the ring
+func (p *PacketFramer) writable() []byte {
+    if p.size == len(p.buf) {
+        return p.buf[:0]
+    }
+
+    tail := p.tail()
+    if tail < p.head {
+        return p.buf[tail:p.head]
+    }
+    return p.buf[tail:]
+}
+
+func (p *PacketFramer) peek(out []byte) {
+    n := copy(out, p.buf[p.head:])
+    if n < len(out) {
+        copy(out[n:], p.buf)
+    }
+}
+
+func (p *PacketFramer) discard(n int) {
+    p.head = (p.head + n) % len(p.buf)
+    p.size -= n
+}
+
+func (p *PacketFramer) read(out []byte) {
+    p.peek(out)
+    p.discard(len(out))
+}
+
+func (p *PacketFramer) commit(n int) error {
+    p.size += n
+
+    logger := slog.Default()
+    if logger.Enabled(context.Background(), prettylog.LevelTrace) {
+        prettylog.Trace(logger, "PacketFramer received bytes", "len", p.size, "pretty bytes", utils.PrettyPrintBytes(p.buf[p.head:], p.size))
+    }
+
+    for {
+        pkt, err := p.pull()
+        if err != nil {
+            return errors.Join(PacketStreamCorrupt, err)
+        }
+
+        if pkt == nil {
+            return nil
+        }
+
+        p.C <- pkt
+    }
+}
+
+func (p *PacketFramer) Push(data []byte) error {
+    for len(data) > 0 {
+        n := copy(p.writable(), data)
+        data = data[n:]
+
+        if err := p.commit(n); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (p *PacketFramer) pull() (*Packet, error) {
+    for {
+        if p.size < 1 {
+            return nil, nil
+        }
+
+        switch p.buf[p.head] {
+        case VERSION:
+            return p.pullV1()
+        case VERSION_2:
+            pkt, consumed, err := p.pullV2()
+            if err != nil || pkt != nil || !consumed {
+                return pkt, err
+            }
+        default:
+            return nil, errors.Join(
+                PacketVersionMismatch,
+                fmt.Errorf("received version: %d", p.buf[p.head]))
+        }
+    }
+}
+
+func (p *PacketFramer) pullV1() (*Packet, error) {
+    if p.size < HEADER_SIZE {
+        return nil, nil
+    }
+
+    if p.verifier.requiredFlags() != 0 {
+        return nil, PacketIntegrityMissing
+    }
+
+    p.peek(p.header[:HEADER_SIZE])
+    packetLen := int(getPacketLength(p.header[:]))
+    fullLen := packetLen + HEADER_SIZE
+    if packetLen >= PACKET_PAYLOAD_SIZE {
+        return nil, PacketMaxSizeExceeded
+    }
+
+    if fullLen > p.size {
+        return nil, nil
+    }
+
+    p.version = VERSION
+    pkt := acquirePacket(fullLen)
+    p.read(pkt.data[:fullLen])
+    return pkt, nil
+}
+
+// pullV2 returns consumed = true when a fragment was taken off the buffer
+// without completing a packet
+func (p *PacketFramer) pullV2() (*Packet, bool, error) {
+    if p.size < HEADER_V2_SIZE {
+        return nil, false, nil
+    }
+
+    p.peek(p.header[:])
+    packetLen := int(getPacketLengthV2(p.header[:]))
+    fullLen := packetLen + HEADER_V2_SIZE
+    if packetLen > PACKET_V2_FRAME_PAYLOAD_SIZE {
+        return nil, false, errors.Join(PacketFrameSizeExceeded, fmt.Errorf("received length: %d", packetLen))
+    }
+
+    flags := p.header[HEADER_V2_FLAGS_OFFSET]
+    typeEnc := p.header[TYPE_ENC_INDEX]
+    trailer := p.trailer[:trailerSize(flags)]
+
+    if fullLen + len(trailer) > p.size {
+        return nil, false, nil
+    }
+
+    p.version = VERSION_2
+
+    if p.fragmenting && typeEnc != p.fragmentTypeEnc {
+        return nil, false, PacketFragmentMismatch
+    }
+
+    if flags & FlagFragment == 0 && !p.fragmenting {
+        pkt := acquirePacket(fullLen)
+        p.read(pkt.data[:fullLen])
+        p.read(trailer)
+
+        if err := p.verifier.verify(p.header[:], pkt.data[HEADER_V2_SIZE:fullLen], trailer); err != nil {
+            pkt.Release()
+            return nil, false, err
+        }
+
+        // the trailer is gone, the flags can't claim otherwise
+        pkt.data[HEADER_V2_FLAGS_OFFSET] &^= FlagChecksum | FlagHMAC
+        return pkt, true, nil
+    }
+
+    start := len(p.fragments)
+    if start + packetLen > PACKET_V2_MAX_PAYLOAD_SIZE {
+        return nil, false, errors.Join(PacketV2MaxSizeExceeded, fmt.Errorf("received length: %d", start + packetLen))
+    }
+
+    p.discard(HEADER_V2_SIZE)
+    p.fragments = slices.Grow(p.fragments, packetLen)[:start + packetLen]
+    p.read(p.fragments[start:])
+    p.read(trailer)
+
+    if err := p.verifier.verify(p.header[:], p.fragments[start:], trailer); err != nil {
+        return nil, false, err
+    }
+
+    p.fragmentTypeEnc = typeEnc
+    p.fragmenting = true
+
+    if flags & FlagFragment != 0 {
+        return nil, true, nil
+    }
+
+    fullLen = HEADER_V2_SIZE + len(p.fragments)
+    pkt := acquirePacket(fullLen)
+    copy(pkt.data, p.header[:])
+    pkt.data[HEADER_V2_FLAGS_OFFSET] = 0
+    binary.BigEndian.PutUint32(pkt.data[HEADER_V2_LENGTH_OFFSET:], uint32(len(p.fragments)))
+    copy(pkt.data[HEADER_V2_SIZE:], p.fragments)
+
+    // don't hold onto a 16MB buffer for the rest of the connection
+    p.fragments = p.fragments[:0]
+    if cap(p.fragments) > FRAMER_BUFFER_SIZE {
+        p.fragments = nil
+    }
+    p.fragmenting = false
+
+    return pkt, true, nil
+}
+
+func (p *PacketFramer) finish(err error) {
+    if err != nil && !errors.Is(err, io.EOF) {
+        p.Err <- err
+    }
+
+    close(p.C)
+    close(p.Err)
+}
+
+// FrameWithReader reads straight into the framer's ring buffer until the
+// reader or the framer returns an error.  The framer is finished afterwards
+// and cannot be reused
+func FrameWithReader(framer *PacketFramer, reader io.Reader) error {
+    err := frameWithReader(framer, reader)
+    framer.finish(err)
+    return err
+}
+
+func frameWithReader(framer *PacketFramer, reader io.Reader) error {
+    for {
+        n, err := reader.Read(framer.writable())
+        if n > 0 {
+            if perr := framer.commit(n); perr != nil {
+                return perr
+            }
+        }
+
+        if err != nil {
+            return err
+        }
+    }
+}
@@ -0,0 +1,94 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var HeartbeatMissed = fmt.Errorf("peer missed too many heartbeats")
+var PacketPongMalformed = fmt.Errorf("pong packet does not contain a timestamp")
+
+type HeartbeatConfig struct {
+    Interval time.Duration
+    // the peer is considered gone after this many pings in a row go unanswered
+    MaxMissed int
+}
+
+func (h HeartbeatConfig) Enabled() bool {
+    return h.Interval > 0 && h.MaxMissed > 0
+}
+
+// Heartbeat tracks the pings sent to one peer.  Every hop owns one per
+// connection, pings and pongs are never forwarded
+type Heartbeat struct {
+    config HeartbeatConfig
+    ticker *time.Ticker
+
+    mutex sync.Mutex
+    missed int
+    rtt time.Duration
+}
+
+func NewHeartbeat(config HeartbeatConfig) *Heartbeat {
+    h := &Heartbeat{config: config}
+    if config.Enabled() {
+        h.ticker = time.NewTicker(config.Interval)
+    }
+    return h
+}
+
+// C ticks every interval.  A disabled heartbeat never ticks
+func (h *Heartbeat) C() <-chan time.Time {
+    if h.ticker == nil {
+        return nil
+    }
+    return h.ticker.C
+}
+
+func (h *Heartbeat) Stop() {
+    if h.ticker != nil {
+        h.ticker.Stop()
+    }
+}
+
+// Beat is called on every tick and returns the ping to send, or
+// HeartbeatMissed once the peer has not answered MaxMissed pings in a row
+func (h *Heartbeat) Beat() (Packet, error) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    if h.missed >= h.config.MaxMissed {
+        return Packet{}, errors.Join(HeartbeatMissed, fmt.Errorf("missed: %d", h.missed))
+    }
+
+    h.missed++
+    return CreatePing(time.Now()), nil
+}
+
+// Pong resets the missed count and records the round trip time
+func (h *Heartbeat) Pong(pkt *Packet) (time.Duration, error) {
+    data := pkt.Data()
+    if pkt.Type() != PacketPong || len(data) != 8 {
+        return 0, PacketPongMalformed
+    }
+
+    sent := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+    rtt := time.Since(sent)
+
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    h.missed = 0
+    h.rtt = rtt
+    return rtt, nil
+}
+
+// RTT is the round trip time of the last pong
+func (h *Heartbeat) RTT() time.Duration {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    return h.rtt
+}
@@ -0,0 +1,46 @@
+package packet_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+func TestHeartbeat(t *testing.T) {
+    heartbeat := packet.NewHeartbeat(packet.HeartbeatConfig{
+        Interval: time.Hour,
+        MaxMissed: 2,
+    })
+    defer heartbeat.Stop()
+
+    ping, err := heartbeat.Beat()
+    require.NoError(t, err)
+    require.True(t, packet.IsPing(&ping))
+
+    _, err = heartbeat.Beat()
+    require.NoError(t, err)
+    _, err = heartbeat.Beat()
+    require.ErrorIs(t, err, packet.HeartbeatMissed)
+
+    time.Sleep(time.Millisecond)
+    pong := packet.CreatePong(&ping)
+    require.True(t, packet.IsPong(&pong))
+    rtt, err := heartbeat.Pong(&pong)
+    require.NoError(t, err)
+    require.Greater(t, rtt, time.Duration(0))
+    require.Equal(t, rtt, heartbeat.RTT())
+
+    _, err = heartbeat.Beat()
+    require.NoError(t, err, "pong should reset the missed count")
+
+    _, err = heartbeat.Pong(&ping)
+    require.ErrorIs(t, err, packet.PacketPongMalformed)
+}
+
+func TestHeartbeatDisabled(t *testing.T) {
+    heartbeat := packet.NewHeartbeat(packet.HeartbeatConfig{})
+    require.Nil(t, heartbeat.C())
+    heartbeat.Stop()
+}
@@ -0,0 +1,137 @@
+package packet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+const CHECKSUM_SIZE = crc32.Size
+const HMAC_SIZE = sha256.Size
+const MAX_TRAILER_SIZE = CHECKSUM_SIZE + HMAC_SIZE
+
+var PacketChecksumMismatch = fmt.Errorf("Packet checksum does not match")
+var PacketSignatureMismatch = fmt.Errorf("Packet signature does not match")
+var PacketIntegrityMissing = fmt.Errorf("Packet is missing the required integrity trailer")
+
+// Integrity is the optional trailer written after every v2 frame.  v1 frames
+// have no flags and therefore can never carry a trailer, so a peer that only
+// speaks v1 keeps working as long as neither side asks for integrity
+type Integrity struct {
+    // CRC32 for corruption detection
+    Checksum bool
+
+    // HMAC-SHA256 key for authenticity, nil to not sign
+    Key []byte
+}
+
+// DeriveKey creates the per game server key from the secret shared by the
+// proxy and every game server
+func DeriveKey(secret []byte, id string) []byte {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(id))
+    return mac.Sum(nil)
+}
+
+func (i *Integrity) Enabled() bool {
+    return i != nil && (i.Checksum || len(i.Key) > 0)
+}
+
+func (i *Integrity) flags() uint8 {
+    if i == nil {
+        return 0
+    }
+
+    var flags uint8 = 0
+    if i.Checksum {
+        flags |= FlagChecksum
+    }
+    if len(i.Key) > 0 {
+        flags |= FlagHMAC
+    }
+    return flags
+}
+
+func (i *Integrity) trailer(out []byte, header []byte, data []byte) []byte {
+    if i.Checksum {
+        sum := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, data)
+        out = binary.BigEndian.AppendUint32(out, sum)
+    }
+
+    if len(i.Key) > 0 {
+        mac := hmac.New(sha256.New, i.Key)
+        mac.Write(header)
+        mac.Write(data)
+        out = mac.Sum(out)
+    }
+
+    return out
+}
+
+func trailerSize(flags uint8) int {
+    size := 0
+    if flags & FlagChecksum != 0 {
+        size += CHECKSUM_SIZE
+    }
+    if flags & FlagHMAC != 0 {
+        size += HMAC_SIZE
+    }
+    return size
+}
+
+// frameVerifier is owned by a single framer so the hmac can be reused
+type frameVerifier struct {
+    integrity Integrity
+    mac hash.Hash
+    sum [HMAC_SIZE]byte
+}
+
+func newFrameVerifier(integrity Integrity) *frameVerifier {
+    v := &frameVerifier{integrity: integrity}
+    if len(integrity.Key) > 0 {
+        v.mac = hmac.New(sha256.New, integrity.Key)
+    }
+    return v
+}
+
+func (v *frameVerifier) requiredFlags() uint8 {
+    return v.integrity.flags()
+}
+
+// verify checks the trailer of a frame.  Frames that carry a trailer are
+// verified even when the framer does not require one, as long as the framer
+// can (a signed frame cannot be verified without a key)
+func (v *frameVerifier) verify(header []byte, data []byte, trailer []byte) error {
+    flags := header[HEADER_V2_FLAGS_OFFSET]
+    if flags & v.requiredFlags() != v.requiredFlags() {
+        return errors.Join(PacketIntegrityMissing, fmt.Errorf("received flags: %d", flags))
+    }
+
+    if flags & FlagChecksum != 0 {
+        expected := binary.BigEndian.Uint32(trailer)
+        sum := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, data)
+        if sum != expected {
+            return PacketChecksumMismatch
+        }
+        trailer = trailer[CHECKSUM_SIZE:]
+    }
+
+    if flags & FlagHMAC != 0 {
+        if v.mac == nil {
+            return errors.Join(PacketSignatureMismatch, fmt.Errorf("framer has no key to verify with"))
+        }
+
+        v.mac.Reset()
+        v.mac.Write(header)
+        v.mac.Write(data)
+        if !hmac.Equal(v.mac.Sum(v.sum[:0]), trailer[:HMAC_SIZE]) {
+            return PacketSignatureMismatch
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,98 @@
+package packet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+func writeWithIntegrity(t *testing.T, p packet.Packet, integrity *packet.Integrity) []byte {
+    buf := bytes.NewBuffer(nil)
+    _, err := p.IntoWithIntegrity(buf, integrity)
+    require.NoError(t, err)
+    return buf.Bytes()
+}
+
+func TestIntegrityRoundTrip(t *testing.T) {
+    key := packet.DeriveKey([]byte("secret"), "69")
+    tests := []struct {
+        name string
+        integrity packet.Integrity
+        size int
+    }{
+        {"checksum", packet.Integrity{Checksum: true}, 42},
+        {"hmac", packet.Integrity{Key: key}, 42},
+        {"both", packet.Integrity{Checksum: true, Key: key}, 42},
+        {"both fragmented", packet.Integrity{Checksum: true, Key: key}, packet.PACKET_V2_FRAME_PAYLOAD_SIZE * 2 + 1},
+    }
+
+    for _, tt := range tests {
+        data := bytes.Repeat([]byte{0x69}, tt.size)
+        p, err := packet.PacketFromParts(packet.PacketItem, packet.EncodingBytes, data)
+        require.NoError(t, err, tt.name)
+
+        framer := packet.NewPacketFramer()
+        framer.SetIntegrity(tt.integrity)
+        go func() {
+            require.NoError(t, framer.Push(writeWithIntegrity(t, p, &tt.integrity)), tt.name)
+        }()
+
+        pkt := <-framer.C
+        require.Equal(t, packet.VERSION_2, pkt.Version(), tt.name)
+        require.Equal(t, uint8(0), pkt.Flags(), tt.name)
+        require.Equal(t, data, pkt.Data(), tt.name)
+        pkt.Release()
+    }
+}
+
+func TestIntegrityErrors(t *testing.T) {
+    key := packet.DeriveKey([]byte("secret"), "69")
+    otherKey := packet.DeriveKey([]byte("secret"), "420")
+    p, err := packet.PacketFromParts(packet.PacketItem, packet.EncodingBytes, []byte("hello integrity"))
+    require.NoError(t, err)
+
+    tamper := func(b []byte) []byte {
+        b[packet.HEADER_V2_SIZE] ^= 0xFF
+        return b
+    }
+
+    tests := []struct {
+        name string
+        written packet.Integrity
+        required packet.Integrity
+        modify func([]byte) []byte
+        err error
+    }{
+        {"corrupt checksum", packet.Integrity{Checksum: true}, packet.Integrity{}, tamper, packet.PacketChecksumMismatch},
+        {"corrupt signed", packet.Integrity{Key: key}, packet.Integrity{Key: key}, tamper, packet.PacketSignatureMismatch},
+        {"wrong key", packet.Integrity{Key: otherKey}, packet.Integrity{Key: key}, nil, packet.PacketSignatureMismatch},
+        {"unsigned", packet.Integrity{Checksum: true}, packet.Integrity{Key: key}, nil, packet.PacketIntegrityMissing},
+        {"v1", packet.Integrity{}, packet.Integrity{Checksum: true}, nil, packet.PacketIntegrityMissing},
+    }
+
+    for _, tt := range tests {
+        b := writeWithIntegrity(t, p, &tt.written)
+        if tt.modify != nil {
+            b = tt.modify(b)
+        }
+
+        framer := packet.NewPacketFramer()
+        framer.SetIntegrity(tt.required)
+        err := framer.Push(b)
+        require.ErrorIs(t, err, tt.err, tt.name)
+        require.True(t, packet.IsStreamCorrupt(err), tt.name)
+    }
+}
+
+func TestIntegrityV1PeersUnaffected(t *testing.T) {
+    p := packet.CreateCloseConnection()
+    b := writeWithIntegrity(t, p, nil)
+    require.Equal(t, packet.VERSION, b[0])
+
+    framer := packet.NewPacketFramer()
+    require.NoError(t, framer.Push(b))
+    pkt := <-framer.C
+    require.True(t, packet.IsCloseConnection(pkt))
+}
@@ -5,14 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
+	"sync"
+	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
-	prettylog "vim-arcade.theprimeagen.com/pkg/pretty-log"
 	"vim-arcade.theprimeagen.com/pkg/utils"
 )
 
 const VERSION uint8 = 1
+const VERSION_2 uint8 = 2
 
 const HEADER_SIZE = 4
 const TYPE_ENC_INDEX = 1
@@ -23,9 +24,35 @@ const PACKET_PAYLOAD_SIZE = 1024 - HEADER_SIZE
 
 const PACKET_AUTH_SIZE = 16 + HEADER_SIZE
 
+// v2 header: version, enc|type, flags, reserved, 32 bit len
+const HEADER_V2_SIZE = 8
+const HEADER_V2_FLAGS_OFFSET = 2
+const HEADER_V2_LENGTH_OFFSET = 4
+
+// a single v2 frame can carry up to 64KB, anything larger is fragmented
+// across several frames and reassembled by the framer
+const PACKET_V2_FRAME_PAYLOAD_SIZE = 64 * 1024
+const PACKET_V2_MAX_PAYLOAD_SIZE = 16 * 1024 * 1024
+
+const (
+    // more fragments of the same packet follow this frame
+    FlagFragment uint8 = 1 << iota
+    // a CRC32 of the header and payload follows the payload
+    FlagChecksum
+    // a HMAC-SHA256 of the header and payload follows the payload (and the
+    // checksum if present)
+    FlagHMAC
+)
+
 var PacketMaxSizeExceeded = fmt.Errorf("Packet length has exceeded allowed size of %d", PACKET_PAYLOAD_SIZE - 1)
-var PacketVersionMismatch = fmt.Errorf("Expected packet version to equal %d", VERSION)
+var PacketV2MaxSizeExceeded = fmt.Errorf("Packet length has exceeded allowed size of %d", PACKET_V2_MAX_PAYLOAD_SIZE)
+var PacketVersionMismatch = fmt.Errorf("Expected packet version to equal %d or %d", VERSION, VERSION_2)
 var PacketBufferNotBigEnough = fmt.Errorf("Buffer could not fit the entire packet")
+var PacketTypeSizeExceeded = fmt.Errorf("Packet type has exceeded allowed size of %d", MAX_TYPE_SIZE)
+var PacketClientAuthMalformed = fmt.Errorf("Client auth packet is malformed")
+var PacketClientResumeMalformed = fmt.Errorf("Client resume packet is malformed")
+var PacketServerAuthMalformed = fmt.Errorf("Server auth response packet is malformed")
+var PacketFragmentMismatch = fmt.Errorf("Packet fragment type does not match the packet being reassembled")
 
 type Encoding uint8
 
@@ -47,11 +74,18 @@ const (
     PacketItem
     PacketItemUpdate
     PacketCloseConnection
+    PacketPing
+    PacketPong
+    PacketClientResume
+    PacketPlayerJoined
 )
 
 type Packet struct {
     data []byte
     len int
+
+    // set when the packet was handed out by a PacketFramer, see Release
+    pool *sync.Pool
 }
 
 type PacketEncoder interface {
@@ -60,7 +94,8 @@ type PacketEncoder interface {
     Encoding() Encoding
 }
 
-func TypeToString(t PacketType) string {
+// typeName is empty for a type this package does not know
+func typeName(t PacketType) string {
     // TODO could be a sweet short :)
     switch t {
     case PacketError: return "Error"
@@ -71,78 +106,301 @@ func TypeToString(t PacketType) string {
     case PacketItem: return "Item"
     case PacketItemUpdate: return "ItemUpdate"
     case PacketCloseConnection: return "CloseConnection"
-    default:
-        assert.Never("packet unknown", "type", t)
+    case PacketPing: return "Ping"
+    case PacketPong: return "Pong"
+    case PacketClientResume: return "ClientResume"
+    case PacketPlayerJoined: return "PlayerJoined"
     }
     return ""
 }
 
+func TypeToString(t PacketType) string {
+    name := typeName(t)
+    if name == "" {
+        assert.Never("packet unknown", "type", t)
+    }
+    return name
+}
+
+// FormatType is TypeToString for types that came off the wire, where an
+// unknown type is the peer's mistake and not a reason to exit
+func FormatType(t PacketType) string {
+    if name := typeName(t); name != "" {
+        return name
+    }
+    return fmt.Sprintf("unknown(%d)", t)
+}
+
 func CreateTypeAndEncodingByte(t PacketType, enc Encoding) byte {
     return uint8(enc << 6) | uint8(t)
 }
 
-func PacketFromParts(t PacketType, enc Encoding, data []byte) Packet {
-    assert.Assert(len(data) < PACKET_PAYLOAD_SIZE, "packet size is too large", "MAX", PACKET_MAX_SIZE - 1, "received", len(data))
-    assert.Assert(t < MAX_TYPE_SIZE, "max type size exceeded", "MAX", MAX_TYPE_SIZE - 1, "received", t)
+func headerSize(version uint8) int {
+    if version == VERSION_2 {
+        return HEADER_V2_SIZE
+    }
+    return HEADER_SIZE
+}
 
-    buf := append([]byte{
-        VERSION,
-        CreateTypeAndEncodingByte(t, enc),
-        0,
-        0,
-    }, data...)
+// PacketFromParts creates a v1 packet when the data fits within a v1 frame and
+// a v2 packet otherwise.  Packets larger than PACKET_V2_FRAME_PAYLOAD_SIZE are
+// fragmented when written with Into
+func PacketFromParts(t PacketType, enc Encoding, data []byte) (Packet, error) {
+    if t > MAX_TYPE_SIZE {
+        return Packet{}, errors.Join(PacketTypeSizeExceeded, fmt.Errorf("received type: %d", t))
+    }
 
-    binary.BigEndian.PutUint16(buf[HEADER_LENGTH_OFFSET:], uint16(len(data)))
+    if len(data) < PACKET_PAYLOAD_SIZE {
+        buf := append([]byte{
+            VERSION,
+            CreateTypeAndEncodingByte(t, enc),
+            0,
+            0,
+        }, data...)
+
+        binary.BigEndian.PutUint16(buf[HEADER_LENGTH_OFFSET:], uint16(len(data)))
+
+        return Packet{
+            data: buf,
+            len: len(buf),
+        }, nil
+    }
+
+    return packetFromPartsV2(t, enc, data)
+}
+
+func packetFromPartsV2(t PacketType, enc Encoding, data []byte) (Packet, error) {
+    if len(data) > PACKET_V2_MAX_PAYLOAD_SIZE {
+        return Packet{}, errors.Join(PacketV2MaxSizeExceeded, fmt.Errorf("received length: %d", len(data)))
+    }
+
+    buf := make([]byte, HEADER_V2_SIZE, HEADER_V2_SIZE + len(data))
+    buf[0] = VERSION_2
+    buf[TYPE_ENC_INDEX] = CreateTypeAndEncodingByte(t, enc)
+    binary.BigEndian.PutUint32(buf[HEADER_V2_LENGTH_OFFSET:], uint32(len(data)))
+    buf = append(buf, data...)
 
     return Packet{
         data: buf,
         len: len(buf),
-    }
+    }, nil
 }
 
-func CreateMessage(msg string) Packet {
+// mustPacketFromParts is for the create functions whose payloads are bounded
+// by the program itself and can never exceed the max size
+func mustPacketFromParts(t PacketType, enc Encoding, data []byte) Packet {
+    pkt, err := PacketFromParts(t, enc, data)
+    assert.NoError(err, "unable to create packet", "type", t, "len", len(data))
+    return pkt
+}
+
+func CreateMessage(msg string) (Packet, error) {
     return PacketFromParts(PacketMessage, EncodingString, []byte(msg))
 }
 
+// CreateErrorPacket truncates the error message to fit into a single v1 frame
 func CreateErrorPacket(err error) Packet {
-    return PacketFromParts(PacketError, EncodingString, []byte(err.Error()))
+    msg := []byte(err.Error())
+    if len(msg) >= PACKET_PAYLOAD_SIZE {
+        msg = msg[:PACKET_PAYLOAD_SIZE - 1]
+    }
+    return mustPacketFromParts(PacketError, EncodingString, msg)
 }
 
+// CreateServerAuthResponse rejects with the reason as the id, an accepted
+// response is CreateServerAuthAccepted
 func CreateServerAuthResponse(accepted bool, id string) Packet {
-    var b uint8 = 1
-    if !accepted {
-        b = 0
+    if accepted {
+        return CreateServerAuthAccepted(id, nil)
     }
 
-    data := []byte{ b }
+    data := []byte{ 0 }
     data = append(data, []byte(id)...)
 
-    return PacketFromParts(PacketServerAuthResponse, EncodingBytes, data)
+    return mustPacketFromParts(PacketServerAuthResponse, EncodingBytes, data)
+}
+
+// CreateServerAuthAccepted hands the client the game server and the token it
+// resumes with.  Without a token it is the original layout, a proxy that does
+// not resume never sends anything else
+//
+//    1 | id
+//    2 | id length:u8 | id | resume token...
+func CreateServerAuthAccepted(id string, resumeToken []byte) Packet {
+    if len(resumeToken) == 0 {
+        data := []byte{ 1 }
+        data = append(data, []byte(id)...)
+        return mustPacketFromParts(PacketServerAuthResponse, EncodingBytes, data)
+    }
+
+    assert.Assert(len(id) <= 255, "game server id is longer than 255 bytes", "id", id)
+
+    data := make([]byte, 0, 2 + len(id) + len(resumeToken))
+    data = append(data, 2, uint8(len(id)))
+    data = append(data, id...)
+    data = append(data, resumeToken...)
+
+    return mustPacketFromParts(PacketServerAuthResponse, EncodingBytes, data)
+}
+
+// CreateClientResume puts a dropped client back onto its game server
+//
+//    id:16 | resume token...
+func CreateClientResume(id []byte, token []byte) (Packet, error) {
+    assert.Assert(len(id) == 16, "cannot create a resume packet that isn't 16 bytes", "len", len(id))
+
+    data := make([]byte, 0, len(id) + len(token))
+    data = append(data, id...)
+    data = append(data, token...)
+    return PacketFromParts(PacketClientResume, EncodingBytes, data)
+}
+
+// ParseClientResume returns the client's id and resume token
+func ParseClientResume(p *Packet) ([]byte, []byte, error) {
+    if p.Type() != PacketClientResume {
+        return nil, nil, errors.Join(PacketClientResumeMalformed, fmt.Errorf("expected client resume, received %s", FormatType(p.Type())))
+    }
+
+    data := p.Data()
+    if len(data) <= 16 {
+        return nil, nil, errors.Join(PacketClientResumeMalformed, fmt.Errorf("missing the id or token: %d bytes", len(data)))
+    }
+
+    return data[:16], data[16:], nil
 }
 
 func CreateCloseConnection() Packet {
     // i think i have a 0 packet size assert...
     // lets find out
-    return PacketFromParts(PacketCloseConnection, EncodingBytes, []byte{})
+    return mustPacketFromParts(PacketCloseConnection, EncodingBytes, []byte{})
 }
 
 func CreateClientAuth(id []byte) Packet {
     assert.Assert(len(id) == 16, "cannot create a auth packet that isn't 16 bytes", "len", len(id))
-    return PacketFromParts(PacketClientAuth, EncodingBytes, id)
+    return mustPacketFromParts(PacketClientAuth, EncodingBytes, id)
+}
+
+// ClientAuth is everything a client authenticates with
+//
+//    id:16 | game length:u8 | game | version:u16 | party length:u8 | party | party size:u8 | token...
+//
+// A packet of only the id is the default game without a party or token, an
+// empty game means the same
+type ClientAuth struct {
+    Id []byte
+    Game string
+    Version uint16
+
+    // empty plays alone, everyone with the same party plays on the same server
+    Party string
+    PartySize uint8
+
+    Token []byte
+}
+
+// CreateClientAuthWithToken appends the game and a token after the id for the
+// proxy to verify.  Tokens can be larger than a v1 frame, hence the error
+func CreateClientAuthWithToken(id []byte, game string, version uint16, token []byte) (Packet, error) {
+    return CreateClientAuthPacket(ClientAuth{
+        Id: id,
+        Game: game,
+        Version: version,
+        Token: token,
+    })
+}
+
+func CreateClientAuthPacket(auth ClientAuth) (Packet, error) {
+    assert.Assert(len(auth.Id) == 16, "cannot create a auth packet that isn't 16 bytes", "len", len(auth.Id))
+    if len(auth.Game) > 255 {
+        return Packet{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("game name is longer than 255 bytes: %d", len(auth.Game)))
+    }
+    if len(auth.Party) > 255 {
+        return Packet{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("party is longer than 255 bytes: %d", len(auth.Party)))
+    }
+
+    data := make([]byte, 0, len(auth.Id) + 1 + len(auth.Game) + 2 + 1 + len(auth.Party) + 1 + len(auth.Token))
+    data = append(data, auth.Id...)
+    data = append(data, uint8(len(auth.Game)))
+    data = append(data, auth.Game...)
+    data = binary.BigEndian.AppendUint16(data, auth.Version)
+    data = append(data, uint8(len(auth.Party)))
+    data = append(data, auth.Party...)
+    data = append(data, auth.PartySize)
+    data = append(data, auth.Token...)
+    return PacketFromParts(PacketClientAuth, EncodingBytes, data)
+}
+
+// ParseClientAuth is for packets straight off the wire, the accessors below
+// assert instead of erroring
+func ParseClientAuth(p *Packet) (ClientAuth, error) {
+    if p.Type() != PacketClientAuth {
+        return ClientAuth{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("expected client auth, received %s", FormatType(p.Type())))
+    }
+
+    data := p.Data()
+    if len(data) < 16 {
+        return ClientAuth{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("id too short: %d", len(data)))
+    }
+
+    auth := ClientAuth{Id: data[:16], Token: []byte{}}
+    data = data[16:]
+    if len(data) == 0 {
+        return auth, nil
+    }
+
+    gameLen := int(data[0])
+    if len(data) < 1 + gameLen + 2 + 1 {
+        return ClientAuth{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("game is cut short: %d bytes for a %d byte name", len(data), gameLen))
+    }
+
+    auth.Game = string(data[1:1 + gameLen])
+    auth.Version = binary.BigEndian.Uint16(data[1 + gameLen:])
+    data = data[1 + gameLen + 2:]
+
+    partyLen := int(data[0])
+    if len(data) < 1 + partyLen + 1 {
+        return ClientAuth{}, errors.Join(PacketClientAuthMalformed, fmt.Errorf("party is cut short: %d bytes for a %d byte party", len(data), partyLen))
+    }
+
+    auth.Party = string(data[1:1 + partyLen])
+    auth.PartySize = data[1 + partyLen]
+    auth.Token = data[1 + partyLen + 1:]
+    return auth, nil
+}
+
+// CreatePing carries the time it was sent, the pong echoes it back so the
+// round trip only ever depends on the sender's clock
+func CreatePing(sent time.Time) Packet {
+    data := binary.BigEndian.AppendUint64(nil, uint64(sent.UnixNano()))
+    return mustPacketFromParts(PacketPing, EncodingBytes, data)
+}
+
+func CreatePong(ping *Packet) Packet {
+    assert.Assert(ping.Type() == PacketPing, "cannot pong a packet that isn't a ping", "packet", ping.String())
+    return mustPacketFromParts(PacketPong, EncodingBytes, ping.Data())
 }
 
 func getPacketLength(data []byte) uint16 {
     return binary.BigEndian.Uint16(data[HEADER_LENGTH_OFFSET:])
 }
 
+func getPacketLengthV2(data []byte) uint32 {
+    return binary.BigEndian.Uint32(data[HEADER_V2_LENGTH_OFFSET:])
+}
+
 func PacketFromBytes(data []byte) Packet {
-    assert.Assert(data[0] == VERSION, "version mismatch: this should be handled by the framer before packet is created", "VERSION", VERSION, "provided", data[0])
+    assert.Assert(data[0] == VERSION || data[0] == VERSION_2, "version mismatch: this should be handled by the framer before packet is created", "VERSION", VERSION, "provided", data[0])
 
-    dataLen := len(data) - HEADER_SIZE
+    dataLen := len(data) - headerSize(data[0])
     assert.Assert(dataLen >= 0, "packets must contain some sort of data")
 
-    encodedLen := getPacketLength(data)
-    assert.Assert(dataLen == int(encodedLen), "the data buffer provided has a length mismatch", "expected length", dataLen, "encoded length", encodedLen)
+    var encodedLen int
+    if data[0] == VERSION_2 {
+        encodedLen = int(getPacketLengthV2(data))
+    } else {
+        encodedLen = int(getPacketLength(data))
+    }
+    assert.Assert(dataLen == encodedLen, "the data buffer provided has a length mismatch", "expected length", dataLen, "encoded length", encodedLen)
 
     return Packet{
         data: data,
@@ -150,35 +408,130 @@ func PacketFromBytes(data []byte) Packet {
     }
 }
 
-func NewPacket(encoder PacketEncoder) Packet {
-    b := make([]byte, PACKET_MAX_SIZE, PACKET_MAX_SIZE)
+// NewPacket reads the encoder until it produces a short read, EOF, or the
+// payload exceeds PACKET_V2_MAX_PAYLOAD_SIZE
+func NewPacket(encoder PacketEncoder) (Packet, error) {
+    t := encoder.Type()
+    if t > MAX_TYPE_SIZE {
+        return Packet{}, errors.Join(PacketTypeSizeExceeded, fmt.Errorf("received type: %d", t))
+    }
 
-    enc := b[HEADER_SIZE:]
-    n, err := encoder.Read(enc)
-    assert.NoError(err, "i should never fail on encoding a packet")
-    assert.Assert(n != PACKET_PAYLOAD_SIZE, "max packet size exceeded", "MAX_SIZE", PACKET_PAYLOAD_SIZE)
+    b := make([]byte, PACKET_PAYLOAD_SIZE, PACKET_PAYLOAD_SIZE)
+    n := 0
+    for {
+        read, err := encoder.Read(b[n:])
+        n += read
 
-    t := encoder.Type()
-    assert.Assert(t <= (0x40 - 1), "type has exceeded allowed size", "type", t)
+        if errors.Is(err, io.EOF) {
+            break
+        } else if err != nil {
+            return Packet{}, err
+        }
+
+        if n < len(b) {
+            break
+        }
 
-    b[0] = VERSION
-    b[1] = uint8(encoder.Encoding() << 6) | encoder.Type()
+        if len(b) > PACKET_V2_MAX_PAYLOAD_SIZE {
+            return Packet{}, PacketV2MaxSizeExceeded
+        }
 
-    binary.BigEndian.PutUint16(b[2:], uint16(n))
+        b = append(b, make([]byte, len(b))...)
+    }
 
-    return Packet{data: b, len: n + HEADER_SIZE}
+    return PacketFromParts(PacketType(t), encoder.Encoding(), b[:n])
 }
 
+// Into writes the packet into the writer.  v2 packets that are larger than a
+// single frame are written as a series of fragments
 func (p *Packet) Into(writer io.Writer) (int, error) {
-    return writer.Write(p.data[:p.len])
+    if p.Version() != VERSION_2 || p.Len() <= PACKET_V2_FRAME_PAYLOAD_SIZE {
+        return writer.Write(p.data[:p.len])
+    }
+
+    return p.writeFrames(writer, nil)
+}
+
+// IntoWithIntegrity writes the packet as v2 frames with the integrity trailer
+// appended to every frame.  Without any integrity this is the same as Into
+func (p *Packet) IntoWithIntegrity(writer io.Writer, integrity *Integrity) (int, error) {
+    if !integrity.Enabled() {
+        return p.Into(writer)
+    }
+
+    return p.writeFrames(writer, integrity)
 }
 
-func (p *Packet) Len() uint16 {
-    return binary.BigEndian.Uint16(p.data[2:])
+func (p *Packet) writeFrames(writer io.Writer, integrity *Integrity) (int, error) {
+    header := make([]byte, HEADER_V2_SIZE, HEADER_V2_SIZE)
+    header[0] = VERSION_2
+    header[TYPE_ENC_INDEX] = p.data[TYPE_ENC_INDEX]
+
+    var trailer []byte
+    if integrity.Enabled() {
+        trailer = make([]byte, 0, MAX_TRAILER_SIZE)
+    }
+
+    wrote := 0
+    data := p.Data()
+    for {
+        size := min(len(data), PACKET_V2_FRAME_PAYLOAD_SIZE)
+
+        header[HEADER_V2_FLAGS_OFFSET] = p.Flags() | integrity.flags()
+        if size < len(data) {
+            header[HEADER_V2_FLAGS_OFFSET] |= FlagFragment
+        }
+        binary.BigEndian.PutUint32(header[HEADER_V2_LENGTH_OFFSET:], uint32(size))
+
+        n, err := writer.Write(header)
+        wrote += n
+        if err != nil {
+            return wrote, err
+        }
+
+        n, err = writer.Write(data[:size])
+        wrote += n
+        if err != nil {
+            return wrote, err
+        }
+
+        if integrity.Enabled() {
+            n, err = writer.Write(integrity.trailer(trailer[:0], header, data[:size]))
+            wrote += n
+            if err != nil {
+                return wrote, err
+            }
+        }
+
+        data = data[size:]
+        if len(data) == 0 {
+            break
+        }
+    }
+
+    return wrote, nil
+}
+
+func (p *Packet) Version() uint8 {
+    return p.data[0]
+}
+
+func (p *Packet) Flags() uint8 {
+    if p.Version() != VERSION_2 {
+        return 0
+    }
+    return p.data[HEADER_V2_FLAGS_OFFSET]
+}
+
+func (p *Packet) Len() int {
+    if p.Version() == VERSION_2 {
+        return int(getPacketLengthV2(p.data))
+    }
+    return int(getPacketLength(p.data))
 }
 
 func (p *Packet) Data() []byte {
-    return p.data[HEADER_SIZE:p.len]
+    return p.data[headerSize(p.Version()):p.len]
 }
 
 // shit
@@ -201,94 +554,91 @@ func (p *Packet) Read(data []byte) (int, error) {
 
 func (p *Packet) String() string {
     prettyData := utils.PrettyPrintBytes(p.Data(), 16)
-    return fmt.Sprintf("Packet(v=%d, t=%s, enc=%d, len=%d) -> \"%s\"", p.data[0], TypeToString(p.Type()), p.Encoding(), p.Len(), prettyData)
+    return fmt.Sprintf("Packet(v=%d, t=%s, enc=%d, len=%d) -> \"%s\"", p.data[0], FormatType(p.Type()), p.Encoding(), p.Len(), prettyData)
 }
 
-type PacketFramer struct {
-    buf []byte
-    idx int
-    C chan *Packet
+func IsCloseConnection(p *Packet) bool {
+    return p.Type() == PacketCloseConnection
 }
-
-func NewPacketFramer() PacketFramer {
-    return PacketFramer{
-        buf: make([]byte, PACKET_PAYLOAD_SIZE, PACKET_PAYLOAD_SIZE),
-        C: make(chan *Packet, 10),
-    }
+func IsPing(p *Packet) bool {
+    return p.Type() == PacketPing
+}
+func IsPong(p *Packet) bool {
+    return p.Type() == PacketPong
+}
+func IsServerAuth(p *Packet) bool {
+    return p.Type() == PacketServerAuthResponse
 }
 
-func (p *PacketFramer) Push(data []byte) error {
-    n := copy(p.buf[p.idx:], data)
-
-    if n < len(data) {
-        p.buf = append(p.buf, data[n:]...)
-    }
-
-    p.idx += len(data)
+func ClientAuthId(p *Packet) []byte {
+    assert.Assert(p.Type() == PacketClientAuth, "cannot cast the packet into a client auth packet", "packet", p.String())
+    data := p.Data()
+    assert.Assert(len(data) >= 16, "client auth packet is missing the id", "len", len(data))
+    return data[:16]
+}
 
-    prettylog.Trace(slog.Default(),"PacketFramer received bytes", "len", p.idx, "pretty bytes", utils.PrettyPrintBytes(p.buf, p.idx))
+// ClientAuthToken is empty when the client did not send one
+func ClientAuthToken(p *Packet) []byte {
+    auth, err := ParseClientAuth(p)
+    assert.NoError(err, "cannot cast the packet into a client auth packet", "packet", p.String())
+    return auth.Token
+}
 
-    for {
-        pkt, err := p.pull()
-        if err != nil || pkt == nil {
-            return err
-        }
+// ClientAuthGame is empty when the client did not pick one
+func ClientAuthGame(p *Packet) (string, uint16) {
+    auth, err := ParseClientAuth(p)
+    assert.NoError(err, "cannot cast the packet into a client auth packet", "packet", p.String())
+    return auth.Game, auth.Version
+}
 
-        p.C <- pkt
-    }
+func ServerAuthAccepted(p *Packet) bool {
+    assert.Assert(p.Type() == PacketServerAuthResponse, "cannot cast the packet into a server auth packet", "packet", p.String())
+    data := p.Data()
+    return len(data) > 0 && (data[0] == 1 || data[0] == 2)
 }
 
-func (p *PacketFramer) pull() (*Packet, error) {
-    if p.idx < HEADER_SIZE {
-        return nil, nil
-    }
+// ServerAuth is the proxy's answer to a ClientAuth or ClientResume
+type ServerAuth struct {
+    Accepted bool
 
-    if p.buf[0] != VERSION {
-        return nil, errors.Join(
-            PacketVersionMismatch,
-            fmt.Errorf("received version: %d", p.buf[0]))
-    }
+    // the game server when accepted
+    GameId string
 
-    packetLen := getPacketLength(p.buf)
-    fullLen := packetLen + HEADER_SIZE
-    if packetLen == PACKET_PAYLOAD_SIZE {
-        return nil, PacketMaxSizeExceeded
-    }
+    // why the proxy said no when not accepted
+    Reason string
 
-    if fullLen <= uint16(p.idx) {
-        out := make([]byte, fullLen, fullLen)
-        copy(out, p.buf[:fullLen])
-        copy(p.buf, p.buf[fullLen:])
-        p.idx = p.idx - int(fullLen)
+    // empty when the proxy does not resume connections
+    ResumeToken []byte
+}
 
-        pkt := PacketFromBytes(out)
-        return &pkt, nil
+// ParseServerAuth reads every layout of the response, the token is a slice of
+// the packet's data
+func ParseServerAuth(p *Packet) (ServerAuth, error) {
+    if p.Type() != PacketServerAuthResponse {
+        return ServerAuth{}, errors.Join(PacketServerAuthMalformed, fmt.Errorf("expected server auth response, received %s", FormatType(p.Type())))
     }
 
-    return nil, nil
-}
+    data := p.Data()
+    if len(data) == 0 {
+        return ServerAuth{}, errors.Join(PacketServerAuthMalformed, fmt.Errorf("empty response"))
+    }
 
-func FrameWithReader(framer *PacketFramer, reader io.Reader) error {
-    data := make([]byte, 100, 100)
-    for {
-        n, err := reader.Read(data)
-        if err != nil {
-            return err
+    switch data[0] {
+    case 0:
+        return ServerAuth{Reason: string(data[1:])}, nil
+    case 1:
+        return ServerAuth{Accepted: true, GameId: string(data[1:])}, nil
+    case 2:
+        if len(data) < 2 || len(data) < 2 + int(data[1]) {
+            return ServerAuth{}, errors.Join(PacketServerAuthMalformed, fmt.Errorf("game server id is cut short: %d bytes", len(data)))
         }
-
-        framer.Push(data[:n])
+        idLen := int(data[1])
+        return ServerAuth{
+            Accepted: true,
+            GameId: string(data[2:2 + idLen]),
+            ResumeToken: data[2 + idLen:],
+        }, nil
+    default:
+        return ServerAuth{}, errors.Join(PacketServerAuthMalformed, fmt.Errorf("unknown response %d", data[0]))
     }
 }
-
-func IsCloseConnection(p *Packet) bool {
-    return p.Type() == PacketCloseConnection
-}
-func IsServerAuth(p *Packet) bool {
-    return p.Type() == PacketServerAuthResponse
-}
-
-// ok here is the other verson of the same thing
-func ServerAuthGameId(p *Packet) string {
-    assert.Assert(p.Type() == PacketServerAuthResponse, "cannot cast the packet into a server auth packet", "packet", p.String())
-    return string(p.data[HEADER_SIZE + 1:])
-}
@@ -0,0 +1,80 @@
+package packet_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+// every op is a single packet so allocs/op is allocs per packet
+func createPacketBytes(b *testing.B) []byte {
+    p, err := packet.CreateItemUpdate(packet.ItemUpdate{Id: 69, X: 4, Y: 2}, packet.EncodingBytes)
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    buf := bytes.NewBuffer(nil)
+    if _, err := p.Into(buf); err != nil {
+        b.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func drain(framer *packet.PacketFramer) chan struct{} {
+    done := make(chan struct{})
+    go func() {
+        for pkt := range framer.C {
+            pkt.Release()
+        }
+        close(done)
+    }()
+    return done
+}
+
+func BenchmarkPacketFramerPush(b *testing.B) {
+    data := createPacketBytes(b)
+    framer := packet.NewPacketFramer()
+    done := drain(&framer)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for range b.N {
+        framer.Push(data)
+    }
+    b.StopTimer()
+    close(framer.C)
+    <-done
+}
+
+type loopReader struct {
+    data []byte
+    idx int
+    remaining int
+}
+
+func (l *loopReader) Read(b []byte) (int, error) {
+    if l.remaining == 0 {
+        return 0, io.EOF
+    }
+    n := copy(b, l.data[l.idx:])
+    l.idx = (l.idx + n) % len(l.data)
+    if l.idx == 0 {
+        l.remaining--
+    }
+    return n, nil
+}
+
+func BenchmarkFrameWithReader(b *testing.B) {
+    data := createPacketBytes(b)
+    framer := packet.NewPacketFramer()
+    reader := &loopReader{data: data, remaining: b.N}
+    done := drain(&framer)
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    packet.FrameWithReader(&framer, reader)
+    b.StopTimer()
+    <-done
+}
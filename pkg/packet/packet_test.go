@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"vim-arcade.theprimeagen.com/pkg/packet"
@@ -28,7 +29,8 @@ func (t *TestEncoding) Read(data []byte) (int, error) {
 
 func TestPacketCreation(t *testing.T) {
     te := TestEncoding{}
-    p := packet.NewPacket(&te)
+    p, err := packet.NewPacket(&te)
+    require.NoError(t, err, "unable to create packet")
 
     data := make([]byte, 0, 100)
     buf := bytes.NewBuffer(data)
@@ -43,7 +45,8 @@ func TestPacketCreation(t *testing.T) {
 
 func TestPacketFramer(t *testing.T) {
     te := TestEncoding{}
-    p := packet.NewPacket(&te)
+    p, err := packet.NewPacket(&te)
+    require.NoError(t, err, "unable to create packet")
 
     data := make([]byte, 0, 100)
     buf := bytes.NewBuffer(data)
@@ -75,7 +78,8 @@ func TestPacketFramer(t *testing.T) {
 
 func TestReaderFramer(t *testing.T) {
     te := TestEncoding{}
-    p := packet.NewPacket(&te)
+    p, err := packet.NewPacket(&te)
+    require.NoError(t, err, "unable to create packet")
 
     data := make([]byte, 0, 100)
     buf := bytes.NewBuffer(data)
@@ -89,7 +93,7 @@ func TestReaderFramer(t *testing.T) {
 
     framer := packet.NewPacketFramer()
 
-    var err error = nil
+    err = nil
     go func() {
         err = packet.FrameWithReader(&framer, buf)
         if !errors.Is(err, io.EOF) {
@@ -137,3 +141,336 @@ func TestPacketFromParts(t *testing.T) {
     require.Equal(t, pkt[1], packet.CreateTypeAndEncodingByte(packet.PacketClientAuth, packet.EncodingBytes))
     require.Equal(t, bLen, uint16(16))
 }
+
+func TestClientAuthRoundTrip(t *testing.T) {
+    id := bytes.Repeat([]byte{0x69}, 16)
+    p, err := packet.CreateClientAuthWithToken(id, "vim-golf", 420, []byte("token"))
+    require.NoError(t, err)
+
+    buf := bytes.NewBuffer(nil)
+    _, err = p.Into(buf)
+    require.NoError(t, err)
+
+    pkt := packet.PacketFromBytes(buf.Bytes())
+    auth, err := packet.ParseClientAuth(&pkt)
+    require.NoError(t, err)
+    require.Equal(t, packet.ClientAuth{
+        Id: id,
+        Game: "vim-golf",
+        Version: 420,
+        Party: "",
+        Token: []byte("token"),
+    }, auth)
+
+    party := packet.ClientAuth{
+        Id: id,
+        Game: "vim-golf",
+        Version: 420,
+        Party: "the-boys",
+        PartySize: 3,
+        Token: []byte{},
+    }
+    p, err = packet.CreateClientAuthPacket(party)
+    require.NoError(t, err)
+    auth, err = packet.ParseClientAuth(&p)
+    require.NoError(t, err)
+    require.Equal(t, party, auth)
+
+    // only the id is the default game
+    p = packet.CreateClientAuth(id)
+    auth, err = packet.ParseClientAuth(&p)
+    require.NoError(t, err)
+    require.Equal(t, "", auth.Game)
+    require.Empty(t, auth.Token)
+
+    // a name longer than the packet
+    p, err = packet.PacketFromParts(packet.PacketClientAuth, packet.EncodingBytes, append(id, 10, 'v'))
+    require.NoError(t, err)
+    _, err = packet.ParseClientAuth(&p)
+    require.ErrorIs(t, err, packet.PacketClientAuthMalformed)
+}
+
+func TestUnknownTypesAreFormatted(t *testing.T) {
+    // nothing uses 62, it is whatever a peer felt like sending
+    p, err := packet.PacketFromParts(62, packet.EncodingBytes, []byte("nope"))
+    require.NoError(t, err)
+
+    require.Equal(t, "unknown(62)", packet.FormatType(p.Type()))
+    require.Equal(t, "Ping", packet.FormatType(packet.PacketPing))
+    require.Contains(t, p.String(), "t=unknown(62)")
+
+    _, err = packet.ParseClientAuth(&p)
+    require.ErrorIs(t, err, packet.PacketClientAuthMalformed)
+    require.ErrorContains(t, err, "unknown(62)")
+}
+
+func TestClientResumeRoundTrip(t *testing.T) {
+    token := []byte("resume-me-please")
+    accepted := packet.CreateServerAuthAccepted("game-server-69", token)
+    require.True(t, packet.ServerAuthAccepted(&accepted))
+    rsp, err := packet.ParseServerAuth(&accepted)
+    require.NoError(t, err)
+    require.Equal(t, packet.ServerAuth{Accepted: true, GameId: "game-server-69", ResumeToken: token}, rsp)
+
+    // a proxy that does not resume answers the way it always has
+    accepted = packet.CreateServerAuthResponse(true, "game-server-69")
+    require.Equal(t, append([]byte{1}, "game-server-69"...), accepted.Data())
+    rsp, err = packet.ParseServerAuth(&accepted)
+    require.NoError(t, err)
+    require.Equal(t, "game-server-69", rsp.GameId)
+    require.Empty(t, rsp.ResumeToken)
+
+    rejected := packet.CreateServerAuthResponse(false, "go away")
+    require.False(t, packet.ServerAuthAccepted(&rejected))
+    rsp, err = packet.ParseServerAuth(&rejected)
+    require.NoError(t, err)
+    require.Equal(t, packet.ServerAuth{Reason: "go away"}, rsp)
+
+    // straight off the wire, nothing to assert on
+    empty, err := packet.PacketFromParts(packet.PacketServerAuthResponse, packet.EncodingBytes, []byte{})
+    require.NoError(t, err)
+    require.False(t, packet.ServerAuthAccepted(&empty))
+    for _, data := range [][]byte{{}, {2}, {2, 20, 'g', 's'}, {3, 'g', 's'}} {
+        malformed, err := packet.PacketFromParts(packet.PacketServerAuthResponse, packet.EncodingBytes, data)
+        require.NoError(t, err)
+        _, err = packet.ParseServerAuth(&malformed)
+        require.ErrorIs(t, err, packet.PacketServerAuthMalformed, data)
+    }
+
+    clientId := bytes.Repeat([]byte{0x42}, 16)
+    p, err := packet.CreateClientResume(clientId, token)
+    require.NoError(t, err)
+
+    buf := bytes.NewBuffer(nil)
+    _, err = p.Into(buf)
+    require.NoError(t, err)
+
+    pkt := packet.PacketFromBytes(buf.Bytes())
+    id, parsed, err := packet.ParseClientResume(&pkt)
+    require.NoError(t, err)
+    require.Equal(t, clientId, id)
+    require.Equal(t, token, parsed)
+
+    // an id without a token
+    p, err = packet.PacketFromParts(packet.PacketClientResume, packet.EncodingBytes, clientId)
+    require.NoError(t, err)
+    _, _, err = packet.ParseClientResume(&p)
+    require.ErrorIs(t, err, packet.PacketClientResumeMalformed)
+
+    auth := packet.CreateClientAuth(clientId)
+    _, _, err = packet.ParseClientResume(&auth)
+    require.ErrorIs(t, err, packet.PacketClientResumeMalformed)
+    // whatever a dropped client felt like sending instead
+    p, err = packet.PacketFromParts(62, packet.EncodingBytes, clientId)
+    require.NoError(t, err)
+    _, _, err = packet.ParseClientResume(&p)
+    require.ErrorIs(t, err, packet.PacketClientResumeMalformed)
+    require.ErrorContains(t, err, "unknown(62)")
+}
+
+func TestPacketFromPartsV2(t *testing.T) {
+    data := bytes.Repeat([]byte{0x42}, packet.PACKET_MAX_SIZE * 2)
+    p, err := packet.PacketFromParts(packet.PacketGameSettings, packet.EncodingBytes, data)
+    require.NoError(t, err)
+
+    require.Equal(t, packet.VERSION_2, p.Version())
+    require.Equal(t, len(data), p.Len())
+    require.Equal(t, data, p.Data())
+
+    buf := bytes.NewBuffer(nil)
+    _, err = p.Into(buf)
+    require.NoError(t, err, "unable to write into buffer")
+
+    pkt := buf.Bytes()
+    require.Equal(t, pkt[0], packet.VERSION_2)
+    require.Equal(t, binary.BigEndian.Uint32(pkt[packet.HEADER_V2_LENGTH_OFFSET:]), uint32(len(data)))
+    require.Equal(t, packet.PacketFromBytes(pkt), p)
+}
+
+func TestPacketMaxSizeExceeded(t *testing.T) {
+    data := make([]byte, packet.PACKET_V2_MAX_PAYLOAD_SIZE + 1)
+    _, err := packet.PacketFromParts(packet.PacketGameSettings, packet.EncodingBytes, data)
+    require.ErrorIs(t, err, packet.PacketV2MaxSizeExceeded)
+
+    _, err = packet.PacketFromParts(packet.MAX_TYPE_SIZE + 1, packet.EncodingBytes, []byte{})
+    require.ErrorIs(t, err, packet.PacketTypeSizeExceeded)
+}
+
+func TestPacketFramerFragmentation(t *testing.T) {
+    data := make([]byte, packet.PACKET_V2_FRAME_PAYLOAD_SIZE * 3 + 69)
+    for i := range data {
+        data[i] = byte(i)
+    }
+
+    large, err := packet.PacketFromParts(packet.PacketGameSettings, packet.EncodingBytes, data)
+    require.NoError(t, err)
+    small, err := packet.CreateMessage("hello v1")
+    require.NoError(t, err)
+
+    buf := bytes.NewBuffer(nil)
+    _, err = small.Into(buf)
+    require.NoError(t, err)
+    _, err = large.Into(buf)
+    require.NoError(t, err)
+    _, err = small.Into(buf)
+    require.NoError(t, err)
+
+    // 4 fragments of the large packet + 2 small packets
+    require.Equal(t, buf.Len(), len(data) + 4 * packet.HEADER_V2_SIZE + 2 * small.Len() + 2 * packet.HEADER_SIZE)
+
+    framer := packet.NewPacketFramer()
+    go func() {
+        err := packet.FrameWithReader(&framer, buf)
+        if !errors.Is(err, io.EOF) {
+            require.NoError(t, err)
+        }
+    }()
+
+    pkt := <-framer.C
+    require.Equal(t, packet.VERSION, pkt.Version())
+    require.Equal(t, []byte("hello v1"), pkt.Data())
+
+    pkt = <-framer.C
+    require.Equal(t, packet.VERSION_2, pkt.Version())
+    require.Equal(t, packet.PacketGameSettings, pkt.Type())
+    require.Equal(t, uint8(0), pkt.Flags())
+    require.Equal(t, data, pkt.Data())
+
+    pkt = <-framer.C
+    require.Equal(t, []byte("hello v1"), pkt.Data())
+}
+
+func TestPacketFramerVersionMismatch(t *testing.T) {
+    framer := packet.NewPacketFramer()
+    err := framer.Push([]byte{69, 0, 0, 0})
+    require.ErrorIs(t, err, packet.PacketVersionMismatch)
+}
+
+func TestPacketFramerRingWrap(t *testing.T) {
+    expected := [][]byte{}
+    buf := bytes.NewBuffer(nil)
+    for i := range 200 {
+        size := (i * 997) % (packet.PACKET_V2_FRAME_PAYLOAD_SIZE / 2)
+        data := bytes.Repeat([]byte{byte(i)}, size)
+        p, err := packet.PacketFromParts(packet.PacketItem, packet.EncodingBytes, data)
+        require.NoError(t, err)
+        _, err = p.Into(buf)
+        require.NoError(t, err)
+        expected = append(expected, data)
+    }
+
+    framer := packet.NewPacketFramer()
+    stream := buf.Bytes()
+    go func() {
+        for i := 0; len(stream) > 0; i++ {
+            n := min(len(stream), 1 + (i * 7919) % 9000)
+            require.NoError(t, framer.Push(stream[:n]))
+            stream = stream[n:]
+        }
+    }()
+
+    for _, data := range expected {
+        pkt := <-framer.C
+        require.Equal(t, len(data), pkt.Len())
+        require.True(t, bytes.Equal(data, pkt.Data()))
+        pkt.Release()
+    }
+}
+
+func TestPacketFramerFrameSizeExceeded(t *testing.T) {
+    header := []byte{packet.VERSION_2, 0, 0, 0, 0, 0, 0, 0}
+    binary.BigEndian.PutUint32(header[packet.HEADER_V2_LENGTH_OFFSET:], packet.PACKET_V2_FRAME_PAYLOAD_SIZE + 1)
+
+    framer := packet.NewPacketFramer()
+    require.ErrorIs(t, framer.Push(header), packet.PacketFrameSizeExceeded)
+}
+
+func TestFrameWithReaderClosesOnEOF(t *testing.T) {
+    p := packet.CreateCloseConnection()
+    buf := bytes.NewBuffer(nil)
+    _, err := p.Into(buf)
+    require.NoError(t, err)
+
+    framer := packet.NewPacketFramer()
+    err = packet.FrameWithReader(&framer, buf)
+    require.ErrorIs(t, err, io.EOF)
+
+    pkt, ok := <-framer.C
+    require.True(t, ok)
+    require.True(t, packet.IsCloseConnection(pkt))
+
+    _, ok = <-framer.C
+    require.False(t, ok, "expected C to be closed on EOF")
+    require.NoError(t, <-framer.Err, "EOF should not be reported")
+}
+
+func TestFrameWithReaderReportsCorruptStream(t *testing.T) {
+    p := packet.CreateCloseConnection()
+    buf := bytes.NewBuffer(nil)
+    _, err := p.Into(buf)
+    require.NoError(t, err)
+    buf.Write([]byte{69, 4, 2, 0})
+
+    framer := packet.NewPacketFramer()
+    go packet.FrameWithReader(&framer, buf)
+
+    pkt, ok := <-framer.C
+    require.True(t, ok)
+    require.True(t, packet.IsCloseConnection(pkt))
+
+    _, ok = <-framer.C
+    require.False(t, ok, "expected C to be closed on a corrupt stream")
+
+    err = <-framer.Err
+    require.True(t, packet.IsStreamCorrupt(err))
+    require.ErrorIs(t, err, packet.PacketVersionMismatch)
+}
+
+func TestPlayerJoinedRoundTrip(t *testing.T) {
+    key := packet.DeriveKey([]byte("secret"), "game-server-69")
+    joined := packet.PlayerJoined{
+        ClientId: bytes.Repeat([]byte{0x69}, 16),
+        ConnId: "deadbeefdeadbeef",
+        Addr: "127.0.0.1:42069",
+        Party: "the-boys",
+        PartySize: 3,
+        Queued: time.Millisecond * 1337,
+        IssuedAt: time.UnixMilli(time.Now().UnixMilli()),
+    }
+
+    p, err := packet.CreatePlayerJoined(joined, key)
+    require.NoError(t, err)
+
+    buf := bytes.NewBuffer(nil)
+    _, err = p.Into(buf)
+    require.NoError(t, err)
+
+    pkt := packet.PacketFromBytes(buf.Bytes())
+    parsed, err := packet.ParsePlayerJoined(&pkt, key)
+    require.NoError(t, err)
+    require.Equal(t, joined.IssuedAt.UnixMilli(), parsed.IssuedAt.UnixMilli())
+    parsed.IssuedAt = joined.IssuedAt
+    require.Equal(t, joined, parsed)
+
+    // another game server's key
+    _, err = packet.ParsePlayerJoined(&pkt, packet.DeriveKey([]byte("secret"), "game-server-420"))
+    require.ErrorIs(t, err, packet.PacketSignatureMismatch)
+
+    // unsigned without a secret
+    p, err = packet.CreatePlayerJoined(joined, nil)
+    require.NoError(t, err)
+    _, err = packet.ParsePlayerJoined(&p, nil)
+    require.NoError(t, err)
+    _, err = packet.ParsePlayerJoined(&p, key)
+    require.ErrorIs(t, err, packet.PacketSignatureMismatch)
+
+    // cut short in the middle of the addr
+    p, err = packet.PacketFromParts(packet.PacketPlayerJoined, packet.EncodingBytes, append(bytes.Repeat([]byte{0x69}, 16), 0, 10, '1'))
+    require.NoError(t, err)
+    _, err = packet.ParsePlayerJoined(&p, nil)
+    require.ErrorIs(t, err, packet.PacketPlayerJoinedMalformed)
+
+    auth := packet.CreateClientAuth(joined.ClientId)
+    _, err = packet.ParsePlayerJoined(&auth, nil)
+    require.ErrorIs(t, err, packet.PacketPlayerJoinedMalformed)
+}
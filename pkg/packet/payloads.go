@@ -0,0 +1,325 @@
+package packet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var PacketTypeMismatch = fmt.Errorf("Packet type does not match the requested payload")
+var PacketEncodingUnknown = fmt.Errorf("Packet encoding is not supported")
+var PacketPayloadMalformed = fmt.Errorf("Packet payload could not be decoded")
+
+// every typed payload has to be able to encode itself with every Encoding
+// EncodingJSON is handled by encoding/json
+type payload interface {
+    marshalBytes() []byte
+    unmarshalBytes(data []byte) error
+    marshalString() string
+    unmarshalString(data string) error
+}
+
+type Item struct {
+    Id uint32 `json:"id"`
+    Kind uint16 `json:"kind"`
+    X float32 `json:"x"`
+    Y float32 `json:"y"`
+    Data []byte `json:"data"`
+}
+
+type ItemUpdate struct {
+    Id uint32 `json:"id"`
+    X float32 `json:"x"`
+    Y float32 `json:"y"`
+    Removed bool `json:"removed"`
+}
+
+type GameSettings struct {
+    TickRateMS uint32 `json:"tickRateMS"`
+    Width uint16 `json:"width"`
+    Height uint16 `json:"height"`
+    MaxPlayers uint16 `json:"maxPlayers"`
+    Seed int64 `json:"seed"`
+}
+
+func malformed(format string, args ...any) error {
+    return errors.Join(PacketPayloadMalformed, fmt.Errorf(format, args...))
+}
+
+func encodePayload(t PacketType, enc Encoding, p payload) (Packet, error) {
+    var data []byte
+    switch enc {
+    case EncodingJSON:
+        d, err := json.Marshal(p)
+        if err != nil {
+            return Packet{}, err
+        }
+        data = d
+    case EncodingString:
+        data = []byte(p.marshalString())
+    case EncodingBytes:
+        data = p.marshalBytes()
+    default:
+        return Packet{}, errors.Join(PacketEncodingUnknown, fmt.Errorf("received encoding: %d", enc))
+    }
+
+    return PacketFromParts(t, enc, data)
+}
+
+func decodePayload(pkt *Packet, t PacketType, p payload) error {
+    if pkt.Type() != t {
+        return errors.Join(PacketTypeMismatch, fmt.Errorf("expected %s received %s", TypeToString(t), FormatType(pkt.Type())))
+    }
+
+    switch pkt.Encoding() {
+    case EncodingJSON:
+        if err := json.Unmarshal(pkt.Data(), p); err != nil {
+            return errors.Join(PacketPayloadMalformed, err)
+        }
+        return nil
+    case EncodingString:
+        return p.unmarshalString(string(pkt.Data()))
+    case EncodingBytes:
+        return p.unmarshalBytes(pkt.Data())
+    }
+
+    return errors.Join(PacketEncodingUnknown, fmt.Errorf("received encoding: %d", pkt.Encoding()))
+}
+
+func CreateItem(item Item, enc Encoding) (Packet, error) {
+    return encodePayload(PacketItem, enc, &item)
+}
+
+func ItemFromPacket(pkt *Packet) (Item, error) {
+    item := Item{}
+    err := decodePayload(pkt, PacketItem, &item)
+    return item, err
+}
+
+func CreateItemUpdate(update ItemUpdate, enc Encoding) (Packet, error) {
+    return encodePayload(PacketItemUpdate, enc, &update)
+}
+
+func ItemUpdateFromPacket(pkt *Packet) (ItemUpdate, error) {
+    update := ItemUpdate{}
+    err := decodePayload(pkt, PacketItemUpdate, &update)
+    return update, err
+}
+
+func CreateGameSettings(settings GameSettings, enc Encoding) (Packet, error) {
+    return encodePayload(PacketGameSettings, enc, &settings)
+}
+
+func GameSettingsFromPacket(pkt *Packet) (GameSettings, error) {
+    settings := GameSettings{}
+    err := decodePayload(pkt, PacketGameSettings, &settings)
+    return settings, err
+}
+
+// Item bytes: id(4) kind(2) x(4) y(4) data...
+const itemBytesSize = 14
+
+func (i *Item) marshalBytes() []byte {
+    out := make([]byte, itemBytesSize, itemBytesSize + len(i.Data))
+    binary.BigEndian.PutUint32(out[0:], i.Id)
+    binary.BigEndian.PutUint16(out[4:], i.Kind)
+    binary.BigEndian.PutUint32(out[6:], math.Float32bits(i.X))
+    binary.BigEndian.PutUint32(out[10:], math.Float32bits(i.Y))
+    return append(out, i.Data...)
+}
+
+func (i *Item) unmarshalBytes(data []byte) error {
+    if len(data) < itemBytesSize {
+        return malformed("item expected at least %d bytes received %d", itemBytesSize, len(data))
+    }
+
+    i.Id = binary.BigEndian.Uint32(data[0:])
+    i.Kind = binary.BigEndian.Uint16(data[4:])
+    i.X = math.Float32frombits(binary.BigEndian.Uint32(data[6:]))
+    i.Y = math.Float32frombits(binary.BigEndian.Uint32(data[10:]))
+    i.Data = nil
+    if len(data) > itemBytesSize {
+        i.Data = append([]byte{}, data[itemBytesSize:]...)
+    }
+    return nil
+}
+
+// Item string: "id kind x y hex(data)"
+func (i *Item) marshalString() string {
+    return fmt.Sprintf("%d %d %s %s %s", i.Id, i.Kind, formatFloat(i.X), formatFloat(i.Y), hex.EncodeToString(i.Data))
+}
+
+func (i *Item) unmarshalString(data string) error {
+    parts := strings.Split(data, " ")
+    if len(parts) != 5 {
+        return malformed("item expected 5 fields received %d", len(parts))
+    }
+
+    var err error
+    var id, kind uint64
+    var x, y float32
+    if id, err = strconv.ParseUint(parts[0], 10, 32); err != nil {
+        return errors.Join(PacketPayloadMalformed, err)
+    }
+    if kind, err = strconv.ParseUint(parts[1], 10, 16); err != nil {
+        return errors.Join(PacketPayloadMalformed, err)
+    }
+    if x, err = parseFloat(parts[2]); err != nil {
+        return err
+    }
+    if y, err = parseFloat(parts[3]); err != nil {
+        return err
+    }
+
+    var itemData []byte
+    if len(parts[4]) > 0 {
+        if itemData, err = hex.DecodeString(parts[4]); err != nil {
+            return errors.Join(PacketPayloadMalformed, err)
+        }
+    }
+
+    i.Id = uint32(id)
+    i.Kind = uint16(kind)
+    i.X = x
+    i.Y = y
+    i.Data = itemData
+    return nil
+}
+
+// ItemUpdate bytes: id(4) x(4) y(4) removed(1)
+const itemUpdateBytesSize = 13
+
+func (i *ItemUpdate) marshalBytes() []byte {
+    out := make([]byte, itemUpdateBytesSize, itemUpdateBytesSize)
+    binary.BigEndian.PutUint32(out[0:], i.Id)
+    binary.BigEndian.PutUint32(out[4:], math.Float32bits(i.X))
+    binary.BigEndian.PutUint32(out[8:], math.Float32bits(i.Y))
+    if i.Removed {
+        out[12] = 1
+    }
+    return out
+}
+
+func (i *ItemUpdate) unmarshalBytes(data []byte) error {
+    if len(data) != itemUpdateBytesSize {
+        return malformed("item update expected %d bytes received %d", itemUpdateBytesSize, len(data))
+    }
+
+    i.Id = binary.BigEndian.Uint32(data[0:])
+    i.X = math.Float32frombits(binary.BigEndian.Uint32(data[4:]))
+    i.Y = math.Float32frombits(binary.BigEndian.Uint32(data[8:]))
+    i.Removed = data[12] == 1
+    return nil
+}
+
+// ItemUpdate string: "id x y removed"
+func (i *ItemUpdate) marshalString() string {
+    return fmt.Sprintf("%d %s %s %t", i.Id, formatFloat(i.X), formatFloat(i.Y), i.Removed)
+}
+
+func (i *ItemUpdate) unmarshalString(data string) error {
+    parts := strings.Split(data, " ")
+    if len(parts) != 4 {
+        return malformed("item update expected 4 fields received %d", len(parts))
+    }
+
+    var err error
+    var id uint64
+    var x, y float32
+    var removed bool
+    if id, err = strconv.ParseUint(parts[0], 10, 32); err != nil {
+        return errors.Join(PacketPayloadMalformed, err)
+    }
+    if x, err = parseFloat(parts[1]); err != nil {
+        return err
+    }
+    if y, err = parseFloat(parts[2]); err != nil {
+        return err
+    }
+    if removed, err = strconv.ParseBool(parts[3]); err != nil {
+        return errors.Join(PacketPayloadMalformed, err)
+    }
+
+    i.Id = uint32(id)
+    i.X = x
+    i.Y = y
+    i.Removed = removed
+    return nil
+}
+
+// GameSettings bytes: tickRateMS(4) width(2) height(2) maxPlayers(2) seed(8)
+const gameSettingsBytesSize = 18
+
+func (g *GameSettings) marshalBytes() []byte {
+    out := make([]byte, gameSettingsBytesSize, gameSettingsBytesSize)
+    binary.BigEndian.PutUint32(out[0:], g.TickRateMS)
+    binary.BigEndian.PutUint16(out[4:], g.Width)
+    binary.BigEndian.PutUint16(out[6:], g.Height)
+    binary.BigEndian.PutUint16(out[8:], g.MaxPlayers)
+    binary.BigEndian.PutUint64(out[10:], uint64(g.Seed))
+    return out
+}
+
+func (g *GameSettings) unmarshalBytes(data []byte) error {
+    if len(data) != gameSettingsBytesSize {
+        return malformed("game settings expected %d bytes received %d", gameSettingsBytesSize, len(data))
+    }
+
+    g.TickRateMS = binary.BigEndian.Uint32(data[0:])
+    g.Width = binary.BigEndian.Uint16(data[4:])
+    g.Height = binary.BigEndian.Uint16(data[6:])
+    g.MaxPlayers = binary.BigEndian.Uint16(data[8:])
+    g.Seed = int64(binary.BigEndian.Uint64(data[10:]))
+    return nil
+}
+
+// GameSettings string: "tickRateMS width height maxPlayers seed"
+func (g *GameSettings) marshalString() string {
+    return fmt.Sprintf("%d %d %d %d %d", g.TickRateMS, g.Width, g.Height, g.MaxPlayers, g.Seed)
+}
+
+func (g *GameSettings) unmarshalString(data string) error {
+    parts := strings.Split(data, " ")
+    if len(parts) != 5 {
+        return malformed("game settings expected 5 fields received %d", len(parts))
+    }
+
+    values := make([]uint64, 4, 4)
+    bits := []int{32, 16, 16, 16}
+    for idx, b := range bits {
+        v, err := strconv.ParseUint(parts[idx], 10, b)
+        if err != nil {
+            return errors.Join(PacketPayloadMalformed, err)
+        }
+        values[idx] = v
+    }
+
+    seed, err := strconv.ParseInt(parts[4], 10, 64)
+    if err != nil {
+        return errors.Join(PacketPayloadMalformed, err)
+    }
+
+    g.TickRateMS = uint32(values[0])
+    g.Width = uint16(values[1])
+    g.Height = uint16(values[2])
+    g.MaxPlayers = uint16(values[3])
+    g.Seed = seed
+    return nil
+}
+
+func formatFloat(f float32) string {
+    return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
+
+func parseFloat(s string) (float32, error) {
+    f, err := strconv.ParseFloat(s, 32)
+    if err != nil {
+        return 0, errors.Join(PacketPayloadMalformed, err)
+    }
+    return float32(f), nil
+}
@@ -0,0 +1,156 @@
+package packet_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"vim-arcade.theprimeagen.com/pkg/packet"
+)
+
+var encodings = []packet.Encoding{
+    packet.EncodingJSON,
+    packet.EncodingString,
+    packet.EncodingBytes,
+}
+
+// round trips through a framer to make sure what is written is what the
+// other side would decode
+func frame(t *testing.T, p packet.Packet) *packet.Packet {
+    buf := bytes.NewBuffer(nil)
+    _, err := p.Into(buf)
+    require.NoError(t, err, "unable to write into buffer")
+
+    framer := packet.NewPacketFramer()
+    require.NoError(t, framer.Push(buf.Bytes()))
+    return <-framer.C
+}
+
+func TestItemCodec(t *testing.T) {
+    tests := []struct {
+        name string
+        item packet.Item
+    }{
+        {"empty", packet.Item{}},
+        {"no data", packet.Item{Id: 69, Kind: 2, X: 4.5, Y: -1.25}},
+        {"data", packet.Item{Id: 420, Kind: 1, X: 1337, Y: 0.1, Data: []byte{0xde, 0xad, 0xbe, 0xef}}},
+        {"large data", packet.Item{Id: 1, Data: bytes.Repeat([]byte{0x42}, packet.PACKET_MAX_SIZE * 2)}},
+    }
+
+    for _, tt := range tests {
+        for _, enc := range encodings {
+            p, err := packet.CreateItem(tt.item, enc)
+            require.NoError(t, err, tt.name)
+            require.Equal(t, packet.PacketItem, p.Type(), tt.name)
+            require.Equal(t, enc, p.Encoding(), tt.name)
+
+            item, err := packet.ItemFromPacket(frame(t, p))
+            require.NoError(t, err, tt.name)
+            if len(tt.item.Data) == 0 {
+                require.Empty(t, item.Data, tt.name)
+                item.Data = tt.item.Data
+            }
+            require.Equal(t, tt.item, item, tt.name, "encoding", enc)
+        }
+    }
+}
+
+func TestItemUpdateCodec(t *testing.T) {
+    tests := []struct {
+        name string
+        update packet.ItemUpdate
+    }{
+        {"empty", packet.ItemUpdate{}},
+        {"moved", packet.ItemUpdate{Id: 69, X: 4.5, Y: -1.25}},
+        {"removed", packet.ItemUpdate{Id: 420, Removed: true}},
+    }
+
+    for _, tt := range tests {
+        for _, enc := range encodings {
+            p, err := packet.CreateItemUpdate(tt.update, enc)
+            require.NoError(t, err, tt.name)
+            require.Equal(t, packet.PacketItemUpdate, p.Type(), tt.name)
+
+            update, err := packet.ItemUpdateFromPacket(frame(t, p))
+            require.NoError(t, err, tt.name)
+            require.Equal(t, tt.update, update, tt.name, "encoding", enc)
+        }
+    }
+}
+
+func TestGameSettingsCodec(t *testing.T) {
+    tests := []struct {
+        name string
+        settings packet.GameSettings
+    }{
+        {"empty", packet.GameSettings{}},
+        {"full", packet.GameSettings{TickRateMS: 16, Width: 80, Height: 24, MaxPlayers: 100, Seed: -69420}},
+    }
+
+    for _, tt := range tests {
+        for _, enc := range encodings {
+            p, err := packet.CreateGameSettings(tt.settings, enc)
+            require.NoError(t, err, tt.name)
+            require.Equal(t, packet.PacketGameSettings, p.Type(), tt.name)
+
+            settings, err := packet.GameSettingsFromPacket(frame(t, p))
+            require.NoError(t, err, tt.name)
+            require.Equal(t, tt.settings, settings, tt.name, "encoding", enc)
+        }
+    }
+}
+
+func TestPayloadErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        pkt func() (packet.Packet, error)
+        decode func(*packet.Packet) error
+        err error
+    }{
+        {
+            "type mismatch",
+            func() (packet.Packet, error) { return packet.CreateItemUpdate(packet.ItemUpdate{}, packet.EncodingBytes) },
+            func(p *packet.Packet) error { _, err := packet.ItemFromPacket(p); return err },
+            packet.PacketTypeMismatch,
+        },
+        {
+            "unknown type",
+            func() (packet.Packet, error) { return packet.PacketFromParts(62, packet.EncodingBytes, []byte("nope")) },
+            func(p *packet.Packet) error { _, err := packet.ItemFromPacket(p); return err },
+            packet.PacketTypeMismatch,
+        },
+        {
+            "short bytes",
+            func() (packet.Packet, error) { return packet.PacketFromParts(packet.PacketItem, packet.EncodingBytes, []byte{1, 2}) },
+            func(p *packet.Packet) error { _, err := packet.ItemFromPacket(p); return err },
+            packet.PacketPayloadMalformed,
+        },
+        {
+            "bad string",
+            func() (packet.Packet, error) { return packet.PacketFromParts(packet.PacketItemUpdate, packet.EncodingString, []byte("1 2 nope false")) },
+            func(p *packet.Packet) error { _, err := packet.ItemUpdateFromPacket(p); return err },
+            packet.PacketPayloadMalformed,
+        },
+        {
+            "bad json",
+            func() (packet.Packet, error) { return packet.PacketFromParts(packet.PacketGameSettings, packet.EncodingJSON, []byte("{")) },
+            func(p *packet.Packet) error { _, err := packet.GameSettingsFromPacket(p); return err },
+            packet.PacketPayloadMalformed,
+        },
+        {
+            "unknown encoding",
+            func() (packet.Packet, error) { return packet.PacketFromParts(packet.PacketGameSettings, packet.EncodingUNUSED2, []byte{}) },
+            func(p *packet.Packet) error { _, err := packet.GameSettingsFromPacket(p); return err },
+            packet.PacketEncodingUnknown,
+        },
+    }
+
+    for _, tt := range tests {
+        p, err := tt.pkt()
+        require.NoError(t, err, tt.name)
+        require.ErrorIs(t, tt.decode(&p), tt.err, tt.name)
+    }
+
+    _, err := packet.CreateItem(packet.Item{}, packet.EncodingUNUSED2)
+    require.ErrorIs(t, err, packet.PacketEncodingUnknown)
+}
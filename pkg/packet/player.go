@@ -0,0 +1,119 @@
+package packet
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "time"
+
+    "vim-arcade.theprimeagen.com/pkg/assert"
+)
+
+var PacketPlayerJoinedMalformed = fmt.Errorf("Player joined packet is malformed")
+
+// PlayerJoined is the first thing the proxy sends a game server on every
+// connection, it is who the game server is talking to
+//
+//    client id:16 | conn length:u8 | conn | addr length:u8 | addr | party length:u8 | party | party size:u8 | queued ms:u32 | issued at ms:i64 | hmac...
+//
+// The hmac is over everything before it with the game server's derived key,
+// see DeriveKey.  It is left off when the proxy has no secret
+type PlayerJoined struct {
+    ClientId []byte
+
+    // the proxy's id for the connection, see AMConnection.Id
+    ConnId string
+
+    // the client's remote address
+    Addr string
+
+    // empty when the player came alone
+    Party string
+    PartySize uint8
+
+    // how long the player waited in the matchmaking queue, 0 when it never did
+    Queued time.Duration
+
+    // when the proxy signed the packet, a game server can refuse an old one
+    IssuedAt time.Time
+}
+
+func CreatePlayerJoined(joined PlayerJoined, key []byte) (Packet, error) {
+    assert.Assert(len(joined.ClientId) == 16, "cannot create a player joined packet without a 16 byte client id", "len", len(joined.ClientId))
+    for i, field := range []string{joined.ConnId, joined.Addr, joined.Party} {
+        if len(field) > 255 {
+            name := []string{"conn", "addr", "party"}[i]
+            return Packet{}, errors.Join(PacketPlayerJoinedMalformed, fmt.Errorf("%s is longer than 255 bytes: %d", name, len(field)))
+        }
+    }
+
+    data := make([]byte, 0, 16 + 3 + len(joined.ConnId) + len(joined.Addr) + len(joined.Party) + 1 + 4 + 8 + HMAC_SIZE)
+    data = append(data, joined.ClientId...)
+    data = append(data, uint8(len(joined.ConnId)))
+    data = append(data, joined.ConnId...)
+    data = append(data, uint8(len(joined.Addr)))
+    data = append(data, joined.Addr...)
+    data = append(data, uint8(len(joined.Party)))
+    data = append(data, joined.Party...)
+    data = append(data, joined.PartySize)
+    data = binary.BigEndian.AppendUint32(data, uint32(joined.Queued.Milliseconds()))
+    data = binary.BigEndian.AppendUint64(data, uint64(joined.IssuedAt.UnixMilli()))
+
+    if len(key) > 0 {
+        mac := hmac.New(sha256.New, key)
+        mac.Write(data)
+        data = mac.Sum(data)
+    }
+
+    return PacketFromParts(PacketPlayerJoined, EncodingBytes, data)
+}
+
+// ParsePlayerJoined verifies the hmac when given a key, without one the hmac
+// is ignored.  Nothing in the returned PlayerJoined points into the packet
+func ParsePlayerJoined(p *Packet, key []byte) (PlayerJoined, error) {
+    if p.Type() != PacketPlayerJoined {
+        return PlayerJoined{}, errors.Join(PacketPlayerJoinedMalformed, fmt.Errorf("expected player joined, received %s", FormatType(p.Type())))
+    }
+
+    data := p.Data()
+    if len(data) < 16 {
+        return PlayerJoined{}, errors.Join(PacketPlayerJoinedMalformed, fmt.Errorf("client id too short: %d", len(data)))
+    }
+
+    joined := PlayerJoined{ClientId: append([]byte{}, data[:16]...)}
+    offset := 16
+
+    fields := []*string{&joined.ConnId, &joined.Addr, &joined.Party}
+    for _, field := range fields {
+        if len(data) < offset + 1 || len(data) < offset + 1 + int(data[offset]) {
+            return PlayerJoined{}, errors.Join(PacketPlayerJoinedMalformed, fmt.Errorf("cut short at byte %d of %d", offset, len(data)))
+        }
+
+        length := int(data[offset])
+        *field = string(data[offset + 1:offset + 1 + length])
+        offset += 1 + length
+    }
+
+    if len(data) < offset + 1 + 4 + 8 {
+        return PlayerJoined{}, errors.Join(PacketPlayerJoinedMalformed, fmt.Errorf("cut short at byte %d of %d", offset, len(data)))
+    }
+
+    joined.PartySize = data[offset]
+    joined.Queued = time.Duration(binary.BigEndian.Uint32(data[offset + 1:])) * time.Millisecond
+    joined.IssuedAt = time.UnixMilli(int64(binary.BigEndian.Uint64(data[offset + 5:])))
+    offset += 1 + 4 + 8
+
+    if len(key) == 0 {
+        return joined, nil
+    }
+
+    mac := hmac.New(sha256.New, key)
+    mac.Write(data[:offset])
+    if !hmac.Equal(mac.Sum(nil), data[offset:]) {
+        return PlayerJoined{}, PacketSignatureMismatch
+    }
+
+    return joined, nil
+}
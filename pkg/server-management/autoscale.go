@@ -0,0 +1,192 @@
+package servermanagement
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+type scaleAction int
+
+const (
+	scaleUp scaleAction = iota
+	scaleDown
+)
+
+type scaleDecision struct {
+	action scaleAction
+
+	// the game a scale up creates
+	game gameserverstats.GameType
+
+	// the server a scale down closes
+	id     string
+	reason string
+}
+
+type gameLoad struct {
+	// ready or idle with no connections, or on their way to ready
+	warm        int
+	ready       int
+	load        float32
+	utilization float32
+}
+
+type scalePlan struct {
+	decisions []scaleDecision
+	games     map[gameserverstats.GameType]*gameLoad
+}
+
+// plan is the autoscaler without the side effects, refresh acts on it.
+// managed are the servers this LocalServers started, the only ones it can
+// close.  Every game gets its own warm pool
+func (l *LocalServers) plan(configs []gameserverstats.GameServerConfig, managed map[string]gameserverstats.GameType, games []gameserverstats.GameType, now time.Time) scalePlan {
+	plan := scalePlan{
+		decisions: []scaleDecision{},
+		games:     map[gameserverstats.GameType]*gameLoad{},
+	}
+	for _, g := range games {
+		plan.games[g] = &gameLoad{}
+	}
+
+	// idle past the timeout, only closed while the warm pool can spare them
+	type expiredServer struct {
+		id      string
+		idleFor time.Duration
+		game    *gameLoad
+		pooled  bool
+	}
+	expired := []expiredServer{}
+
+	seen := map[string]struct{}{}
+	for _, c := range configs {
+		seen[c.Id] = struct{}{}
+		if l.IsDraining(c.Id) {
+			continue
+		}
+
+		// games without a binary have no warm pool to keep
+		game, pooled := plan.games[c.Game()]
+		if !pooled {
+			game = &gameLoad{}
+		}
+
+		switch c.State {
+		case gameserverstats.GSStateInitializing:
+			game.warm++
+		case gameserverstats.GSStateReady:
+			game.ready++
+			game.load += c.Load
+			if c.Connections == 0 {
+				game.warm++
+			}
+		case gameserverstats.GSStateIdle:
+			// the runner goes idle without connections, it is still up
+			if c.Connections > 0 {
+				continue
+			}
+			game.warm++
+
+			if _, ok := managed[c.Id]; !ok || l.params.IdleTimeout == 0 {
+				continue
+			}
+
+			idleFor := now.Sub(time.UnixMilli(c.LastUpdateMS))
+			if idleFor >= l.params.IdleTimeout {
+				expired = append(expired, expiredServer{id: c.Id, idleFor: idleFor, game: game, pooled: pooled})
+			}
+		}
+	}
+
+	// started, but yet to write any stats
+	for id, g := range managed {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		if game, ok := plan.games[g]; ok {
+			game.warm++
+		}
+	}
+
+	// the longest idle go first, closing one the pool needs would only have
+	// it created again
+	slices.SortStableFunc(expired, func(a, b expiredServer) int {
+		return cmp.Compare(b.idleFor, a.idleFor)
+	})
+	for _, e := range expired {
+		if e.pooled && e.game.warm <= l.params.MinWarmServers {
+			continue
+		}
+
+		e.game.warm--
+		plan.decisions = append(plan.decisions, scaleDecision{
+			action: scaleDown,
+			id:     e.id,
+			reason: fmt.Sprintf("idle for %s, timeout is %s", e.idleFor.Round(time.Millisecond), l.params.IdleTimeout),
+		})
+	}
+
+	for _, g := range games {
+		game := plan.games[g]
+		if game.ready > 0 && l.params.MaxLoad > 0 {
+			game.utilization = game.load / (float32(game.ready) * l.params.MaxLoad)
+		}
+
+		if game.warm < l.params.MinWarmServers {
+			for range l.params.MinWarmServers - game.warm {
+				plan.decisions = append(plan.decisions, scaleDecision{
+					action: scaleUp,
+					game:   g,
+					reason: fmt.Sprintf("warm pool of %d is below the minimum of %d", game.warm, l.params.MinWarmServers),
+				})
+			}
+		} else if game.warm == 0 && l.params.ScaleUpLoad > 0 && game.utilization >= l.params.ScaleUpLoad {
+			// a warm server is already room to grow, so only pre create without one
+			plan.decisions = append(plan.decisions, scaleDecision{
+				action: scaleUp,
+				game:   g,
+				reason: fmt.Sprintf("utilization %.2f of %d ready servers passed %.2f", game.utilization, game.ready, l.params.ScaleUpLoad),
+			})
+		}
+	}
+
+	return plan
+}
+
+func (l *LocalServers) refresh(ctx context.Context) {
+	configs, err := l.stats.GetAllGameServerConfigs()
+	if err != nil {
+		l.logger.Error("autoscaler unable to read game servers", "error", err)
+		return
+	}
+
+	games, err := Games()
+	if err != nil {
+		l.logger.Error("autoscaler unable to read game types", "error", err)
+		return
+	}
+
+	plan := l.plan(configs, l.managedGames(), games, l.now())
+	if len(plan.decisions) == 0 {
+		for game, load := range plan.games {
+			l.logger.Info("autoscaler holding", "reason", "warm pool and load within limits",
+				"game", game.String(), "warm", load.warm, "ready", load.ready, "utilization", load.utilization)
+		}
+		return
+	}
+
+	for _, d := range plan.decisions {
+		switch d.action {
+		case scaleUp:
+			id, err := l.CreateNewServer(ctx, d.game)
+			l.logger.Warn("autoscaler scaling up", "reason", d.reason, "game", d.game.String(), "id", id, "error", err)
+		case scaleDown:
+			l.logger.Warn("autoscaler scaling down", "reason", d.reason, "id", d.id)
+			l.closeServer(d.id)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package servermanagement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+var planNow = time.Unix(69420, 0)
+
+func server(id string, state gameserverstats.State, connections int, updatedAgo time.Duration) gameserverstats.GameServerConfig {
+	return gameserverstats.GameServerConfig{
+		Id:           id,
+		GameType:     gameserverstats.DefaultGame.Name,
+		GameVersion:  gameserverstats.DefaultGame.Version,
+		State:        state,
+		Connections:  connections,
+		Load:         float32(connections) * 0.001,
+		LastUpdateMS: planNow.Add(-updatedAgo).UnixMilli(),
+	}
+}
+
+var defaultGames = []gameserverstats.GameType{gameserverstats.DefaultGame}
+
+func managed(ids ...string) map[string]gameserverstats.GameType {
+	out := map[string]gameserverstats.GameType{}
+	for _, id := range ids {
+		out[id] = gameserverstats.DefaultGame
+	}
+	return out
+}
+
+func actions(plan scalePlan) []scaleAction {
+	out := []scaleAction{}
+	for _, d := range plan.decisions {
+		out = append(out, d.action)
+	}
+	return out
+}
+
+func TestPlanFillsWarmPool(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, MinWarmServers: 2})
+
+	plan := local.plan(nil, managed(), defaultGames, planNow)
+	require.Equal(t, []scaleAction{scaleUp, scaleUp}, actions(plan))
+
+	// a server still starting up counts as warm, with or without stats
+	plan = local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateInitializing, 0, 0),
+		server("1", gameserverstats.GSStateReady, 3, 0),
+	}, managed("0", "1", "2"), defaultGames, planNow)
+	require.Equal(t, 2, plan.games[gameserverstats.DefaultGame].warm)
+	require.Empty(t, plan.decisions)
+}
+
+func TestPlanDrainingServersAreNotWarm(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, MinWarmServers: 1})
+	configs := []gameserverstats.GameServerConfig{server("0", gameserverstats.GSStateReady, 0, 0)}
+
+	require.Empty(t, local.plan(configs, managed("0"), defaultGames, planNow).decisions)
+
+	local.draining["0"] = struct{}{}
+	require.Equal(t, []scaleAction{scaleUp}, actions(local.plan(configs, managed("0"), defaultGames, planNow)))
+}
+
+func TestPlanScalesUpOnLoad(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, ScaleUpLoad: 0.8})
+
+	below := []gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateReady, 8, 0),
+		server("1", gameserverstats.GSStateReady, 7, 0),
+	}
+	plan := local.plan(below, managed("0", "1"), defaultGames, planNow)
+	require.InDelta(t, 0.75, plan.games[gameserverstats.DefaultGame].utilization, 0.001)
+	require.Empty(t, plan.decisions)
+
+	above := []gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateReady, 9, 0),
+		server("1", gameserverstats.GSStateReady, 8, 0),
+	}
+	require.Equal(t, []scaleAction{scaleUp}, actions(local.plan(above, managed("0", "1"), defaultGames, planNow)))
+
+	// a warm server is already somewhere to go
+	above = append(above, server("2", gameserverstats.GSStateReady, 0, 0))
+	local.params.ScaleUpLoad = 0.5
+	require.Empty(t, local.plan(above, managed("0", "1", "2"), defaultGames, planNow).decisions)
+}
+
+func TestPlanClosesIdleServers(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, IdleTimeout: time.Minute})
+
+	plan := local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateIdle, 0, time.Minute*2),
+		server("1", gameserverstats.GSStateIdle, 0, time.Second*10),
+		server("2", gameserverstats.GSStateIdle, 1, time.Minute*2),
+		server("3", gameserverstats.GSStateReady, 0, time.Minute*2),
+
+		// someone else's server
+		server("4", gameserverstats.GSStateIdle, 0, time.Minute*2),
+	}, managed("0", "1", "2", "3"), defaultGames, planNow)
+
+	require.Len(t, plan.decisions, 1)
+	require.Equal(t, scaleDown, plan.decisions[0].action)
+	require.Equal(t, "0", plan.decisions[0].id)
+
+	local.params.IdleTimeout = 0
+	require.Empty(t, local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateIdle, 0, time.Hour),
+	}, managed("0"), defaultGames, planNow).decisions)
+}
+
+func TestPlanIdleServersAreWarm(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, MinWarmServers: 2, IdleTimeout: time.Minute})
+
+	// the runner goes idle on its own, that is no reason to create more
+	require.Empty(t, local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateIdle, 0, time.Second*10),
+		server("1", gameserverstats.GSStateIdle, 0, time.Second*10),
+	}, managed("0", "1"), defaultGames, planNow).decisions)
+
+	// past the timeout only the ones the pool can spare close, longest idle
+	// first
+	plan := local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateIdle, 0, time.Minute*2),
+		server("1", gameserverstats.GSStateIdle, 0, time.Minute*5),
+		server("2", gameserverstats.GSStateIdle, 0, time.Minute*3),
+		server("3", gameserverstats.GSStateIdle, 0, time.Minute*4),
+	}, managed("0", "1", "2", "3"), defaultGames, planNow)
+
+	require.Equal(t, []scaleAction{scaleDown, scaleDown}, actions(plan))
+	require.Equal(t, "1", plan.decisions[0].id)
+	require.Equal(t, "3", plan.decisions[1].id)
+	require.Equal(t, 2, plan.games[gameserverstats.DefaultGame].warm)
+
+	// an idle server with players is no room for anyone else
+	plan = local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateIdle, 1, time.Minute*2),
+		server("1", gameserverstats.GSStateIdle, 0, time.Minute*2),
+	}, managed("0", "1"), defaultGames, planNow)
+	require.Equal(t, []scaleAction{scaleUp}, actions(plan))
+}
+
+func TestPlanWarmPoolPerGame(t *testing.T) {
+	local := NewLocalServers(nil, ServerParams{MaxLoad: 0.01, MinWarmServers: 1})
+	other := gameserverstats.GameType{Name: "other", Version: 2}
+	games := append(defaultGames, other)
+
+	// a warm server of one game is no room for the other
+	plan := local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateReady, 0, 0),
+	}, managed("0"), games, planNow)
+
+	require.Len(t, plan.decisions, 1)
+	require.Equal(t, scaleUp, plan.decisions[0].action)
+	require.Equal(t, other, plan.decisions[0].game)
+
+	ids := managed("0")
+	ids["1"] = other
+	require.Empty(t, local.plan([]gameserverstats.GameServerConfig{
+		server("0", gameserverstats.GSStateReady, 0, 0),
+	}, ids, games, planNow).decisions)
+}
@@ -0,0 +1,70 @@
+package servermanagement
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+const DEFAULT_GAME_BINARY = "./cmd/api-server/main.go"
+
+// gameBinaries reads GAME_SERVERS, a comma separated list of
+// name@version=path.  The default game is always there, from GAME_SERVER
+// when it is not listed
+func gameBinaries() (map[gameserverstats.GameType]string, []gameserverstats.GameType, error) {
+	defaultBinary := os.Getenv("GAME_SERVER")
+	if defaultBinary == "" {
+		defaultBinary = DEFAULT_GAME_BINARY
+	}
+
+	binaries := map[gameserverstats.GameType]string{
+		gameserverstats.DefaultGame: defaultBinary,
+	}
+	games := []gameserverstats.GameType{gameserverstats.DefaultGame}
+
+	for _, entry := range strings.Split(os.Getenv("GAME_SERVERS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		gameStr, path, ok := strings.Cut(entry, "=")
+		if !ok || path == "" {
+			return nil, nil, fmt.Errorf("GAME_SERVERS entry should be name@version=path: %q", entry)
+		}
+
+		game, err := gameserverstats.ParseGameType(gameStr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := binaries[game]; !ok {
+			games = append(games, game)
+		}
+		binaries[game] = path
+	}
+
+	return binaries, games, nil
+}
+
+// GameBinary is the go program LocalServers runs to host the game
+func GameBinary(game gameserverstats.GameType) (string, error) {
+	binaries, _, err := gameBinaries()
+	if err != nil {
+		return "", err
+	}
+
+	binary, ok := binaries[game]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", UnknownGameType, game.String())
+	}
+	return binary, nil
+}
+
+// Games are the games LocalServers can launch, the default game first
+func Games() ([]gameserverstats.GameType, error) {
+	_, games, err := gameBinaries()
+	return games, err
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"vim-arcade.theprimeagen.com/pkg/assert"
@@ -17,12 +20,32 @@ type LocalServers struct {
 	logger  *slog.Logger
 	stats   gameserverstats.GSSRetriever
 	params  ServerParams
-	servers []*cmd.Cmder
+
+	// every process started, servers are created concurrently by the broker
+	// and the autoscaler
+	serversM sync.Mutex
+	servers  []*cmd.Cmder
 
 	load        float32
 	connections float32
 
 	lastTimeNoConnections bool
+
+	// draining servers keep their connections but never get new ones
+	drainingM sync.Mutex
+	draining  map[string]struct{}
+
+	// a server's process holds its reservation until it exits
+	quota *ServerQuota
+
+	// servers started by this LocalServers that have yet to exit, the
+	// autoscaler only ever closes these
+	managedM sync.Mutex
+	managed  map[string]managedServer
+
+	now func() time.Time
+
+	selection SelectionPolicy
 }
 
 func getEnvVars() []string {
@@ -34,35 +57,149 @@ func getEnvVars() []string {
 }
 
 func NewLocalServers(stats gameserverstats.GSSRetriever, params ServerParams) LocalServers {
+	assert.NoError(params.Validate(), "invalid server params")
+	selection, err := NewSelectionPolicy(params.Selection, params.Region, params.RegionLatency)
+	assert.NoError(err, "invalid selection policy")
+
 	return LocalServers{
 		stats:                 stats,
 		params:                params,
 		servers:               []*cmd.Cmder{},
 		logger:                slog.Default().With("area", "LocalServers"),
 		lastTimeNoConnections: false,
+		draining:              map[string]struct{}{},
+		quota:                 NewServerQuota(params),
+		managed:               map[string]managedServer{},
+		now:                   time.Now,
+		selection:             selection,
 	}
 }
 
-func (l *LocalServers) GetBestServer() (string, error) {
-	servers := l.stats.GetServersByUtilization(float64(l.params.MaxLoad))
+// WithSelectionPolicy replaces the policy from ServerParams
+func (l *LocalServers) WithSelectionPolicy(selection SelectionPolicy) *LocalServers {
+	l.selection = selection
+	return l
+}
+
+// GetBestServer only returns a server with room for every one of the
+// connections
+func (l *LocalServers) GetBestServer(game gameserverstats.GameType, connections int) (string, error) {
+	// a server is picked while its load is under MaxLoad, so the first
+	// connection needs no room of its own
+	maxLoad := float64(l.params.MaxLoad) - float64(connections-1)*gameserverstats.LOAD_PER_CONNECTION
+	servers := l.stats.GetServersByUtilization(game, maxLoad)
+
+	candidates := make([]gameserverstats.GameServerConfig, 0, len(servers))
+	for _, s := range servers {
+		if !l.IsDraining(s.Id) {
+			candidates = append(candidates, s)
+		}
+	}
 
-	if len(servers) == 0 {
-		l.logger.Info("GetBestServer no servers found")
+	s, ok := l.selection.Select(candidates, l.params.MaxLoad)
+	if !ok {
+		l.logger.Info("GetBestServer no servers found", "game", game.String(), "connections", connections, "candidates", len(servers))
 		return "", NoBestServer
 	}
 
-	l.logger.Info("GetBestServer server returned", "server", servers[0].String())
-	return servers[0].Id, nil
+	l.logger.Info("GetBestServer server returned", "server", s.String(), "policy", l.selection.String())
+	return s.Id, nil
+}
+
+func (l *LocalServers) SetDraining(id string, draining bool) error {
+	if l.stats.GetById(id) == nil {
+		return fmt.Errorf("%w: %s", UnknownServer, id)
+	}
+
+	l.drainingM.Lock()
+	defer l.drainingM.Unlock()
+
+	if draining {
+		l.draining[id] = struct{}{}
+	} else {
+		delete(l.draining, id)
+	}
+
+	l.logger.Warn("SetDraining", "id", id, "draining", draining)
+	return nil
+}
+
+func (l *LocalServers) IsDraining(id string) bool {
+	l.drainingM.Lock()
+	defer l.drainingM.Unlock()
+
+	_, ok := l.draining[id]
+	return ok
 }
 
 var id = 0
 
-func (l *LocalServers) CreateNewServer(ctx context.Context) (string, error) {
-    dummyServer := os.Getenv("GAME_SERVER")
-    if dummyServer == "" {
-        dummyServer = "./cmd/api-server/main.go"
-    }
+type managedServer struct {
+	game   gameserverstats.GameType
+	cancel context.CancelFunc
+}
+
+// nextServer hands out the id and remembers how to close the server
+func (l *LocalServers) nextServer(game gameserverstats.GameType, cancel context.CancelFunc) int {
+	l.managedM.Lock()
+	defer l.managedM.Unlock()
+
 	outId := id
+	id++
+	l.managed[fmt.Sprintf("%d", outId)] = managedServer{game: game, cancel: cancel}
+	return outId
+}
+
+func (l *LocalServers) forgetServer(id string) {
+	l.managedM.Lock()
+	defer l.managedM.Unlock()
+	delete(l.managed, id)
+}
+
+func (l *LocalServers) managedGames() map[string]gameserverstats.GameType {
+	l.managedM.Lock()
+	defer l.managedM.Unlock()
+
+	out := make(map[string]gameserverstats.GameType, len(l.managed))
+	for id, m := range l.managed {
+		out[id] = m.game
+	}
+	return out
+}
+
+// closeServer kills the server's process and marks it closed, it does not
+// wait for the process to exit
+func (l *LocalServers) closeServer(id string) {
+	l.managedM.Lock()
+	server, ok := l.managed[id]
+	l.managedM.Unlock()
+	if !ok {
+		return
+	}
+
+	server.cancel()
+	if config := l.stats.GetById(id); config != nil {
+		config.State = gameserverstats.GSStateClosed
+		if err := l.stats.Update(*config); err != nil {
+			l.logger.Error("unable to mark server closed", "id", id, "error", err)
+		}
+	}
+}
+
+func (l *LocalServers) CreateNewServer(outer context.Context, game gameserverstats.GameType) (string, error) {
+	dummyServer, err := GameBinary(game)
+	if err != nil {
+		l.logger.Warn("CreateNewServer refused", "error", err)
+		return "", err
+	}
+
+	if err := l.quota.Reserve(); err != nil {
+		l.logger.Warn("CreateNewServer refused", "error", err)
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(outer)
+	outId := l.nextServer(game, cancel)
     // TODO i bet there is a better way of doing this...
     // i just don't know other than straight passthrough?
     // i feel like i need more intelligent passing of logs from inner to outer
@@ -77,12 +214,15 @@ func (l *LocalServers) CreateNewServer(ctx context.Context) (string, error) {
 			return len(b), nil
 		})
 
-	id++
-
 	go func() {
+		defer cancel()
+
         vars := getEnvVars()
         vars = append(vars,
             fmt.Sprintf("ID=%d", outId),
+            fmt.Sprintf("GAME_TYPE=%s", game.Name),
+            fmt.Sprintf("GAME_VERSION=%d", game.Version),
+            fmt.Sprintf("REGION=%s", l.params.Region),
 
             // subprocesses should not have the log file as it will cause odd
             // log file truncation
@@ -90,6 +230,9 @@ func (l *LocalServers) CreateNewServer(ctx context.Context) (string, error) {
         )
 
 		err := cmdr.Run(vars)
+		l.quota.Release()
+		l.forgetServer(fmt.Sprintf("%d", outId))
+
         cancelled := false
         select {
         case <-ctx.Done():
@@ -121,14 +264,20 @@ func (l *LocalServers) CreateNewServer(ctx context.Context) (string, error) {
 		}
 	}()
 
+	l.serversM.Lock()
 	l.servers = append(l.servers, cmdr)
+	l.serversM.Unlock()
 	return fmt.Sprintf("%d", outId), nil
 }
 
-// TODO Add timeout...?
+// WaitForReady is ServerClosed when the server closed before it was ready
 func (l *LocalServers) WaitForReady(ctx context.Context, id string) error {
 	for {
-		time.Sleep(time.Millisecond * 50)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 50):
+		}
 
 		gs := l.stats.GetById(id)
 		l.logger.Info("WaitForReady", "id", id, "gs", gs)
@@ -136,31 +285,46 @@ func (l *LocalServers) WaitForReady(ctx context.Context, id string) error {
 			if gs.State == gameserverstats.GSStateReady {
 				return nil
 			} else if gs.State == gameserverstats.GSStateClosed {
-				// TODO Add closed error
-				return nil
+				return fmt.Errorf("%w: %s closed before it was ready", ServerClosed, id)
 			}
 		}
 	}
 }
 
+// Capacity is how many connections fit under MaxLoad, every game has the same
+func (l *LocalServers) Capacity(game gameserverstats.GameType) int {
+	if l.params.MaxLoad <= 0 {
+		return 0
+	}
+
+	// a server is picked while its load is under MaxLoad, float32 is close
+	// enough that the epsilon keeps 0.9 at 900 connections and not 901
+	return int(math.Ceil(float64(l.params.MaxLoad)/gameserverstats.LOAD_PER_CONNECTION - 1e-6))
+}
+
 func (l *LocalServers) GetConnectionString(id string) (string, error) {
 	gs := l.stats.GetById(id)
 	if gs == nil {
-		// TODO Handle DNE error
-		return "", nil
+		return "", fmt.Errorf("%w: %s", UnknownServer, id)
+	}
+	if gs.State == gameserverstats.GSStateClosed {
+		return "", fmt.Errorf("%w: %s", ServerClosed, id)
 	}
 	return fmt.Sprintf("%s:%d", gs.Host, gs.Port), nil
 }
 
-func (l *LocalServers) refresh(ctx context.Context) {
-}
-
 func (l *LocalServers) Run(ctx context.Context) {
+	interval := l.params.RefreshInterval
+	if interval == 0 {
+		interval = time.Second * 30
+	}
 
-	// TODO(v1) make this configurable
-	timer := time.NewTicker(time.Second * 30)
+	timer := time.NewTicker(interval)
 	defer timer.Stop()
 
+	// the warm pool should not wait a whole interval to exist
+	l.refresh(ctx)
+
 outer:
 	for {
 		select {
@@ -173,18 +337,22 @@ outer:
 }
 
 func (l *LocalServers) Close() {
-	for _, c := range l.servers {
+	l.serversM.Lock()
+	servers := slices.Clone(l.servers)
+	l.serversM.Unlock()
+
+	for _, c := range servers {
 		c.Close()
 	}
 }
 
+// Ready is a no-op, Run creates the warm pool (MinWarmServers) on start
 func (l *LocalServers) Ready() {
-	// TODO i should maybe create one server IF there are no servers
 }
 
 func (l *LocalServers) String() string {
 	servers := []string{}
-	gameServers := l.stats.GetServersByUtilization(1500)
+	gameServers, _ := l.stats.GetAllGameServerConfigs()
 	for _, gs := range gameServers {
 		servers = append(servers, gs.String())
 	}
@@ -0,0 +1,78 @@
+package servermanagement
+
+import (
+	"sync"
+	"time"
+)
+
+const CREATE_BUDGET_WINDOW = time.Minute
+
+// ServerQuota enforces MaxServers and MaxCreatesPerMinute for a backend.
+// Every successful Reserve needs a Release once the server is gone
+type ServerQuota struct {
+	mutex   sync.Mutex
+	params  ServerParams
+	running int
+
+	// oldest first, only the last CREATE_BUDGET_WINDOW is kept
+	creates []time.Time
+
+	now func() time.Time
+}
+
+func NewServerQuota(params ServerParams) *ServerQuota {
+	return &ServerQuota{
+		params:  params,
+		creates: []time.Time{},
+		now:     time.Now,
+	}
+}
+
+// Reserve counts the server as running before it exists so concurrent
+// creates cannot go over the limits
+func (q *ServerQuota) Reserve() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.params.MaxServers > 0 && q.running >= q.params.MaxServers {
+		return &LimitError{Err: ServerLimitReached, Limit: q.params.MaxServers}
+	}
+
+	now := q.now()
+	q.prune(now)
+	if q.params.MaxCreatesPerMinute > 0 && len(q.creates) >= q.params.MaxCreatesPerMinute {
+		return &LimitError{
+			Err:        CreateBudgetExhausted,
+			Limit:      q.params.MaxCreatesPerMinute,
+			RetryAfter: q.creates[0].Add(CREATE_BUDGET_WINDOW).Sub(now),
+		}
+	}
+
+	q.running++
+	q.creates = append(q.creates, now)
+	return nil
+}
+
+func (q *ServerQuota) Release() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.running > 0 {
+		q.running--
+	}
+}
+
+func (q *ServerQuota) Running() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.running
+}
+
+func (q *ServerQuota) prune(now time.Time) {
+	cutoff := now.Add(-CREATE_BUDGET_WINDOW)
+	idx := 0
+	for idx < len(q.creates) && !q.creates[idx].After(cutoff) {
+		idx++
+	}
+	q.creates = q.creates[idx:]
+}
@@ -0,0 +1,73 @@
+package servermanagement
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testQuota(params ServerParams) (*ServerQuota, *time.Time) {
+	now := time.Unix(69420, 0)
+	quota := NewServerQuota(params)
+	quota.now = func() time.Time { return now }
+	return quota, &now
+}
+
+func TestQuotaUnlimited(t *testing.T) {
+	quota, _ := testQuota(ServerParams{})
+	for range 1000 {
+		require.NoError(t, quota.Reserve())
+	}
+	require.Equal(t, 1000, quota.Running())
+}
+
+func TestQuotaMaxServers(t *testing.T) {
+	quota, _ := testQuota(ServerParams{MaxServers: 2})
+
+	require.NoError(t, quota.Reserve())
+	require.NoError(t, quota.Reserve())
+
+	err := quota.Reserve()
+	require.ErrorIs(t, err, ServerLimitReached)
+	require.True(t, IsLimitReached(err))
+
+	var limit *LimitError
+	require.True(t, errors.As(err, &limit))
+	require.Equal(t, 2, limit.Limit)
+	require.Zero(t, limit.RetryAfter)
+
+	quota.Release()
+	require.NoError(t, quota.Reserve())
+}
+
+func TestQuotaCreateBudget(t *testing.T) {
+	quota, now := testQuota(ServerParams{MaxCreatesPerMinute: 2})
+
+	require.NoError(t, quota.Reserve())
+	*now = now.Add(20 * time.Second)
+	require.NoError(t, quota.Reserve())
+
+	// releasing does not refund the budget, only time does
+	quota.Release()
+	quota.Release()
+
+	err := quota.Reserve()
+	require.ErrorIs(t, err, CreateBudgetExhausted)
+
+	var limit *LimitError
+	require.True(t, errors.As(err, &limit))
+	require.Equal(t, 40*time.Second, limit.RetryAfter)
+
+	*now = now.Add(40 * time.Second)
+	require.NoError(t, quota.Reserve())
+	require.ErrorIs(t, quota.Reserve(), CreateBudgetExhausted)
+}
+
+func TestServerParamsValidate(t *testing.T) {
+	require.NoError(t, ServerParams{MaxServers: 2, MinWarmServers: 2}.Validate())
+	require.NoError(t, ServerParams{MinWarmServers: 5}.Validate())
+	require.Error(t, ServerParams{MaxServers: 1, MinWarmServers: 2}.Validate())
+	require.Error(t, ServerParams{MaxCreatesPerMinute: -1}.Validate())
+}
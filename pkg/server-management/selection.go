@@ -0,0 +1,234 @@
+package servermanagement
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+var UnknownSelectionPolicy = fmt.Errorf("unknown selection policy")
+
+const (
+	SELECTION_MOST_LOADED     = "most-loaded"
+	SELECTION_LEAST_LOADED    = "least-loaded"
+	SELECTION_ROUND_ROBIN     = "round-robin"
+	SELECTION_REGION          = "region"
+	SELECTION_WEIGHTED_RANDOM = "weighted-random"
+)
+
+// SelectionPolicy picks the server a connection goes to.  Every candidate
+// already has room for the connection, most loaded first
+type SelectionPolicy interface {
+	Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool)
+	String() string
+}
+
+// NewSelectionPolicy is the policy by its name, empty is most-loaded.  region
+// and latency are only used by the region policy
+func NewSelectionPolicy(name string, region string, latency map[string]time.Duration) (SelectionPolicy, error) {
+	switch name {
+	case "", SELECTION_MOST_LOADED:
+		return &MostLoaded{}, nil
+	case SELECTION_LEAST_LOADED:
+		return &LeastLoaded{}, nil
+	case SELECTION_ROUND_ROBIN:
+		return &RoundRobin{}, nil
+	case SELECTION_REGION:
+		if region == "" {
+			return nil, fmt.Errorf("the %s selection policy needs a region", SELECTION_REGION)
+		}
+		return &RegionAffinity{Region: region, Latency: latency, Fallback: &MostLoaded{}}, nil
+	case SELECTION_WEIGHTED_RANDOM:
+		return NewWeightedRandom(time.Now().UnixNano()), nil
+	default:
+		return nil, fmt.Errorf("%w: %q, expected one of %s", UnknownSelectionPolicy, name, strings.Join([]string{
+			SELECTION_MOST_LOADED, SELECTION_LEAST_LOADED, SELECTION_ROUND_ROBIN, SELECTION_REGION, SELECTION_WEIGHTED_RANDOM,
+		}, ", "))
+	}
+}
+
+// MostLoaded bin packs, servers fill up before the next one gets anyone so
+// the empty ones can be closed
+type MostLoaded struct{}
+
+func (p *MostLoaded) Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool) {
+	if len(candidates) == 0 {
+		return gameserverstats.GameServerConfig{}, false
+	}
+
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Load > best.Load {
+			best = c
+		}
+	}
+	return best, true
+}
+
+func (p *MostLoaded) String() string {
+	return SELECTION_MOST_LOADED
+}
+
+// LeastLoaded spreads connections over every server
+type LeastLoaded struct{}
+
+func (p *LeastLoaded) Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool) {
+	if len(candidates) == 0 {
+		return gameserverstats.GameServerConfig{}, false
+	}
+
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Load < best.Load {
+			best = c
+		}
+	}
+	return best, true
+}
+
+func (p *LeastLoaded) String() string {
+	return SELECTION_LEAST_LOADED
+}
+
+// RoundRobin goes through the servers in id order, starting after the last
+// one it picked.  Servers coming and going do not reset where it is
+type RoundRobin struct {
+	mutex sync.Mutex
+	last  string
+}
+
+func (p *RoundRobin) Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool) {
+	if len(candidates) == 0 {
+		return gameserverstats.GameServerConfig{}, false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sorted := slices.Clone(candidates)
+	slices.SortFunc(sorted, func(a, b gameserverstats.GameServerConfig) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	next := sorted[0]
+	for _, c := range sorted {
+		if c.Id > p.last {
+			next = c
+			break
+		}
+	}
+
+	p.last = next.Id
+	return next, true
+}
+
+func (p *RoundRobin) String() string {
+	return SELECTION_ROUND_ROBIN
+}
+
+// RegionAffinity keeps connections in the region when there is room.  When
+// the region is full the other regions are tried by their Latency from this
+// one, closest first, and regions without one last as a group.  The Fallback
+// picks between the servers of the first group that has any
+type RegionAffinity struct {
+	Region   string
+	Latency  map[string]time.Duration
+	Fallback SelectionPolicy
+}
+
+func (p *RegionAffinity) Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool) {
+	best := []gameserverstats.GameServerConfig{}
+	var bestLatency time.Duration
+	bestKnown := false
+
+	for _, c := range candidates {
+		latency, known := p.Latency[c.Region]
+		if c.Region == p.Region {
+			latency, known = 0, true
+		}
+
+		switch {
+		case len(best) == 0, known && (!bestKnown || latency < bestLatency):
+			best = []gameserverstats.GameServerConfig{c}
+			bestLatency, bestKnown = latency, known
+		case known == bestKnown && (!known || latency == bestLatency):
+			best = append(best, c)
+		}
+	}
+
+	return p.Fallback.Select(best, maxLoad)
+}
+
+func (p *RegionAffinity) String() string {
+	return fmt.Sprintf("%s(%s, %s)", SELECTION_REGION, p.Region, p.Fallback.String())
+}
+
+// ParseRegionLatency reads the latency to other regions as
+// `region=ms,region=ms`, empty is none
+func ParseRegionLatency(s string) (map[string]time.Duration, error) {
+	latency := map[string]time.Duration{}
+	if s == "" {
+		return latency, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		region, msStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || region == "" {
+			return nil, fmt.Errorf("region latency %q is not region=ms", entry)
+		}
+		ms, err := strconv.Atoi(msStr)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("region latency %q is not region=ms", entry)
+		}
+		latency[region] = time.Duration(ms) * time.Millisecond
+	}
+	return latency, nil
+}
+
+// WeightedRandom picks at random, weighted by the room a server has left so
+// emptier servers are more likely
+type WeightedRandom struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func NewWeightedRandom(seed int64) *WeightedRandom {
+	return &WeightedRandom{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (p *WeightedRandom) Select(candidates []gameserverstats.GameServerConfig, maxLoad float32) (gameserverstats.GameServerConfig, bool) {
+	if len(candidates) == 0 {
+		return gameserverstats.GameServerConfig{}, false
+	}
+
+	// a candidate always has room, the minimum keeps a server that is a
+	// rounding error from full in the running
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, c := range candidates {
+		weights[i] = max(float64(maxLoad-c.Load), gameserverstats.LOAD_PER_CONNECTION)
+		total += weights[i]
+	}
+
+	p.mutex.Lock()
+	pick := p.rand.Float64() * total
+	p.mutex.Unlock()
+
+	for i, w := range weights {
+		if pick < w {
+			return candidates[i], true
+		}
+		pick -= w
+	}
+	return candidates[len(candidates)-1], true
+}
+
+func (p *WeightedRandom) String() string {
+	return SELECTION_WEIGHTED_RANDOM
+}
@@ -0,0 +1,194 @@
+package servermanagement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	gameserverstats "vim-arcade.theprimeagen.com/pkg/game-server-stats"
+)
+
+// ten connections per server
+var selectionParams = ServerParams{MaxLoad: 0.01}
+
+func memoryServer(id string, connections int, region string) gameserverstats.GameServerConfig {
+	return gameserverstats.GameServerConfig{
+		Id:          id,
+		GameType:    gameserverstats.DefaultGame.Name,
+		GameVersion: gameserverstats.DefaultGame.Version,
+		State:       gameserverstats.GSStateReady,
+		Connections: connections,
+		Load:        float32(connections) * gameserverstats.LOAD_PER_CONNECTION,
+		Region:      region,
+	}
+}
+
+func selectionServers(t *testing.T, policy SelectionPolicy, configs ...gameserverstats.GameServerConfig) *LocalServers {
+	stats := gameserverstats.NewMemory()
+	for _, c := range configs {
+		require.NoError(t, stats.Update(c))
+	}
+
+	local := NewLocalServers(stats, selectionParams)
+	return local.WithSelectionPolicy(policy)
+}
+
+func bestServer(t *testing.T, local *LocalServers) string {
+	id, err := local.GetBestServer(gameserverstats.DefaultGame, 1)
+	require.NoError(t, err)
+	return id
+}
+
+func TestSelectionMostLoaded(t *testing.T) {
+	local := selectionServers(t, &MostLoaded{},
+		memoryServer("0", 2, ""),
+		memoryServer("1", 7, ""),
+		memoryServer("2", 10, ""),
+	)
+
+	// 2 is full
+	require.Equal(t, "1", bestServer(t, local))
+
+	local.draining["1"] = struct{}{}
+	require.Equal(t, "0", bestServer(t, local))
+}
+
+func TestSelectionLeastLoaded(t *testing.T) {
+	local := selectionServers(t, &LeastLoaded{},
+		memoryServer("0", 2, ""),
+		memoryServer("1", 1, ""),
+		memoryServer("2", 7, ""),
+	)
+	require.Equal(t, "1", bestServer(t, local))
+
+	// room for the whole party
+	id, err := local.GetBestServer(gameserverstats.DefaultGame, 9)
+	require.NoError(t, err)
+	require.Equal(t, "1", id)
+
+	_, err = local.GetBestServer(gameserverstats.DefaultGame, 10)
+	require.ErrorIs(t, err, NoBestServer)
+}
+
+func TestSelectionRoundRobin(t *testing.T) {
+	local := selectionServers(t, &RoundRobin{},
+		memoryServer("a", 5, ""),
+		memoryServer("b", 1, ""),
+		memoryServer("c", 9, ""),
+	)
+
+	picks := []string{}
+	for range 4 {
+		picks = append(picks, bestServer(t, local))
+	}
+	require.Equal(t, []string{"a", "b", "c", "a"}, picks)
+
+	// b fills up, the rotation carries on from a
+	require.NoError(t, local.stats.Update(memoryServer("b", 10, "")))
+	require.Equal(t, "c", bestServer(t, local))
+	require.Equal(t, "a", bestServer(t, local))
+}
+
+func TestSelectionRegionAffinity(t *testing.T) {
+	local := selectionServers(t, &RegionAffinity{Region: "ord", Fallback: &LeastLoaded{}},
+		memoryServer("0", 1, "sjc"),
+		memoryServer("1", 6, "ord"),
+		memoryServer("2", 4, "ord"),
+	)
+	require.Equal(t, "2", bestServer(t, local))
+
+	// out of room in the region, anywhere else will do
+	require.NoError(t, local.stats.Update(memoryServer("1", 10, "ord")))
+	require.NoError(t, local.stats.Update(memoryServer("2", 10, "ord")))
+	require.Equal(t, "0", bestServer(t, local))
+}
+
+func TestSelectionRegionLatency(t *testing.T) {
+	policy := &RegionAffinity{
+		Region: "ord",
+		Latency: map[string]time.Duration{
+			"iad": time.Millisecond * 20,
+			"sjc": time.Millisecond * 50,
+		},
+		Fallback: &LeastLoaded{},
+	}
+	local := selectionServers(t, policy,
+		memoryServer("0", 0, "ams"),
+		memoryServer("1", 5, "sjc"),
+		memoryServer("2", 3, "sjc"),
+		memoryServer("3", 9, "iad"),
+		memoryServer("4", 8, "ord"),
+	)
+	require.Equal(t, "4", bestServer(t, local))
+
+	// ord is full, iad is the closest even though it is the busiest
+	require.NoError(t, local.stats.Update(memoryServer("4", 10, "ord")))
+	require.Equal(t, "3", bestServer(t, local))
+
+	require.NoError(t, local.stats.Update(memoryServer("3", 10, "iad")))
+	require.Equal(t, "2", bestServer(t, local))
+
+	// a region without a latency is only used once the known ones are full
+	require.NoError(t, local.stats.Update(memoryServer("1", 10, "sjc")))
+	require.NoError(t, local.stats.Update(memoryServer("2", 10, "sjc")))
+	require.Equal(t, "0", bestServer(t, local))
+}
+
+func TestParseRegionLatency(t *testing.T) {
+	latency, err := ParseRegionLatency("iad=20, sjc=50")
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{
+		"iad": time.Millisecond * 20,
+		"sjc": time.Millisecond * 50,
+	}, latency)
+
+	latency, err = ParseRegionLatency("")
+	require.NoError(t, err)
+	require.Empty(t, latency)
+
+	for _, bad := range []string{"iad", "=20", "iad=fast", "iad=-1", "iad=20,"} {
+		_, err := ParseRegionLatency(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestSelectionWeightedRandom(t *testing.T) {
+	configs := []gameserverstats.GameServerConfig{
+		memoryServer("0", 9, ""),
+		memoryServer("1", 0, ""),
+	}
+
+	picks := map[string]int{}
+	local := selectionServers(t, NewWeightedRandom(69), configs...)
+	for range 1000 {
+		picks[bestServer(t, local)]++
+	}
+
+	// 1 has ten times the room of 0
+	require.Equal(t, 1000, picks["0"]+picks["1"])
+	require.InDelta(t, 1000.0/11, picks["0"], 30)
+
+	// the same seed makes the same picks
+	again := selectionServers(t, NewWeightedRandom(69), configs...)
+	for range 1000 {
+		picks[bestServer(t, again)]--
+	}
+	require.Equal(t, map[string]int{"0": 0, "1": 0}, picks)
+}
+
+func TestNewSelectionPolicy(t *testing.T) {
+	for _, name := range []string{"", SELECTION_MOST_LOADED, SELECTION_LEAST_LOADED, SELECTION_ROUND_ROBIN, SELECTION_WEIGHTED_RANDOM} {
+		_, err := NewSelectionPolicy(name, "", nil)
+		require.NoError(t, err, name)
+	}
+
+	_, err := NewSelectionPolicy(SELECTION_REGION, "", nil)
+	require.Error(t, err)
+	policy, err := NewSelectionPolicy(SELECTION_REGION, "ord", map[string]time.Duration{"iad": time.Millisecond * 20})
+	require.NoError(t, err)
+	require.Equal(t, "region(ord, most-loaded)", policy.String())
+
+	_, err = NewSelectionPolicy("closest", "", nil)
+	require.ErrorIs(t, err, UnknownSelectionPolicy)
+	require.ErrorIs(t, ServerParams{Selection: "closest"}.Validate(), UnknownSelectionPolicy)
+}
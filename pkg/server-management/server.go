@@ -1,9 +1,153 @@
 package servermanagement
 
-import "errors"
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "time"
+)
 
 var NoBestServer = errors.New("no best server found")
+var UnknownServer = errors.New("unknown server")
+var ServerClosed = errors.New("server closed")
+var ServerLimitReached = errors.New("server limit reached")
+var CreateBudgetExhausted = errors.New("server create budget exhausted")
+var UnknownGameType = errors.New("unknown game type")
+
+// LimitError is what a backend returns instead of creating a server it is
+// not allowed to.  Err is one of ServerLimitReached or CreateBudgetExhausted
+type LimitError struct {
+    Err   error
+    Limit int
+
+    // 0 when there is no telling, MaxServers frees up when a server exits
+    RetryAfter time.Duration
+}
+
+func (l *LimitError) Error() string {
+    if l.RetryAfter > 0 {
+        return fmt.Sprintf("%s: limit %d, retry after %s", l.Err, l.Limit, l.RetryAfter)
+    }
+    return fmt.Sprintf("%s: limit %d", l.Err, l.Limit)
+}
+
+func (l *LimitError) Unwrap() error {
+    return l.Err
+}
+
+// IsLimitReached is true for any LimitError, the caller should wait for
+// capacity instead of treating it as a failure
+func IsLimitReached(err error) bool {
+    var limit *LimitError
+    return errors.As(err, &limit)
+}
 
 type ServerParams struct {
     MaxLoad float32
+
+    // 0 is unlimited
+    MaxServers int
+
+    // servers kept running ahead of demand, never more than MaxServers
+    MinWarmServers int
+
+    // sliding window of a minute, 0 is unlimited
+    MaxCreatesPerMinute int
+
+    // the autoscaler creates a server once the load of the ready servers
+    // passes this fraction of their capacity (MaxLoad each), 0 never does
+    ScaleUpLoad float32
+
+    // idle servers with no connections are closed once they have not
+    // updated for this long, unless the warm pool needs them.  0 leaves
+    // them to close themselves
+    IdleTimeout time.Duration
+
+    // how often the autoscaler runs, 0 is every 30 seconds
+    RefreshInterval time.Duration
+
+    // see NewSelectionPolicy, empty is most-loaded
+    Selection string
+
+    // the region servers are started in and the region policy prefers
+    Region string
+
+    // how far the other regions are from Region, the region policy tries
+    // the closest first once Region is full
+    RegionLatency map[string]time.Duration
+}
+
+func (s ServerParams) Validate() error {
+    if s.MaxServers < 0 || s.MinWarmServers < 0 || s.MaxCreatesPerMinute < 0 ||
+        s.ScaleUpLoad < 0 || s.IdleTimeout < 0 || s.RefreshInterval < 0 {
+        return fmt.Errorf("server params cannot be negative: %+v", s)
+    }
+
+    if s.MaxServers > 0 && s.MinWarmServers > s.MaxServers {
+        return fmt.Errorf("MinWarmServers (%d) cannot exceed MaxServers (%d)", s.MinWarmServers, s.MaxServers)
+    }
+
+    if _, err := NewSelectionPolicy(s.Selection, s.Region, s.RegionLatency); err != nil {
+        return err
+    }
+
+    return nil
+}
+
+func readInt(key string, d int) (int, error) {
+    vStr := os.Getenv(key)
+    if vStr == "" {
+        return d, nil
+    }
+    return strconv.Atoi(vStr)
+}
+
+func readFloat(key string, d float32) (float32, error) {
+    vStr := os.Getenv(key)
+    if vStr == "" {
+        return d, nil
+    }
+    v, err := strconv.ParseFloat(vStr, 32)
+    return float32(v), err
+}
+
+// ServerParamsFromEnv reads MAX_SERVERS, MIN_WARM_SERVERS,
+// MAX_CREATES_PER_MINUTE, SCALE_UP_LOAD, IDLE_TIMEOUT_MS and
+// REFRESH_INTERVAL_MS, all default to 0.  SELECTION_POLICY, REGION and
+// REGION_LATENCY_MS, see ParseRegionLatency, default to empty
+func ServerParamsFromEnv(maxLoad float32) (ServerParams, error) {
+    params := ServerParams{
+        MaxLoad: maxLoad,
+        Selection: os.Getenv("SELECTION_POLICY"),
+        Region: os.Getenv("REGION"),
+    }
+
+    var err error
+    var ms int
+    if params.MaxServers, err = readInt("MAX_SERVERS", 0); err != nil {
+        return params, err
+    }
+    if params.MinWarmServers, err = readInt("MIN_WARM_SERVERS", 0); err != nil {
+        return params, err
+    }
+    if params.MaxCreatesPerMinute, err = readInt("MAX_CREATES_PER_MINUTE", 0); err != nil {
+        return params, err
+    }
+    if params.ScaleUpLoad, err = readFloat("SCALE_UP_LOAD", 0); err != nil {
+        return params, err
+    }
+    if ms, err = readInt("IDLE_TIMEOUT_MS", 0); err != nil {
+        return params, err
+    }
+    params.IdleTimeout = time.Duration(ms) * time.Millisecond
+    if ms, err = readInt("REFRESH_INTERVAL_MS", 0); err != nil {
+        return params, err
+    }
+    params.RefreshInterval = time.Duration(ms) * time.Millisecond
+    if params.RegionLatency, err = ParseRegionLatency(os.Getenv("REGION_LATENCY_MS")); err != nil {
+        return params, err
+    }
+
+    return params, params.Validate()
 }
@@ -0,0 +1,171 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type certFiles struct {
+	cert string
+	key  string
+}
+
+func selfSigned(t *testing.T, name string) certFiles {
+	dir := t.TempDir()
+	files := certFiles{
+		cert: filepath.Join(dir, name+".pem"),
+		key:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, WriteSelfSigned(files.cert, files.key, "127.0.0.1", "localhost"))
+	return files
+}
+
+// serve echoes on every connection until the test is over
+func serve(t *testing.T, config *tls.Config) string {
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// peer is the certificate the server presented, or the handshake's error
+func peer(t *testing.T, addr string, config *tls.Config) (*x509.Certificate, error) {
+	conn, err := tls.Dial("tcp4", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// a server refusing the client's cert only says so after the handshake
+	if _, err := conn.Write([]byte("vim")); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 3)); err != nil {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func leaf(t *testing.T, files certFiles) *x509.Certificate {
+	cert, err := tls.LoadX509KeyPair(files.cert, files.key)
+	require.NoError(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return parsed
+}
+
+// replace copies from over to, pushing the modification time forward so the
+// change is seen no matter the file system's clock resolution
+func replace(t *testing.T, from string, to string, offset time.Duration) {
+	data, err := os.ReadFile(from)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(to, data, 0600))
+
+	later := time.Now().Add(offset)
+	require.NoError(t, os.Chtimes(to, later, later))
+}
+
+func TestServerReloadsCertificate(t *testing.T) {
+	first := selfSigned(t, "first")
+	second := selfSigned(t, "second")
+	firstLeaf, secondLeaf := leaf(t, first), leaf(t, second)
+
+	config, err := ServerConfig(first.cert, first.key, "")
+	require.NoError(t, err)
+	addr := serve(t, config)
+
+	// trusts both so the only question is which one is served
+	client, err := ClientConfig("", "", first.cert)
+	require.NoError(t, err)
+	client.RootCAs.AddCert(secondLeaf)
+
+	got, err := peer(t, addr, client)
+	require.NoError(t, err)
+	require.Equal(t, firstLeaf.Raw, got.Raw)
+
+	// half way through a rotation the pair does not match
+	replace(t, second.cert, first.cert, time.Second)
+	got, err = peer(t, addr, client)
+	require.NoError(t, err)
+	require.Equal(t, firstLeaf.Raw, got.Raw)
+
+	replace(t, second.key, first.key, time.Second)
+	got, err = peer(t, addr, client)
+	require.NoError(t, err)
+	require.Equal(t, secondLeaf.Raw, got.Raw)
+
+	// garbage is not loaded either
+	require.NoError(t, os.WriteFile(first.cert, []byte("nope"), 0600))
+	later := time.Now().Add(time.Second * 2)
+	require.NoError(t, os.Chtimes(first.cert, later, later))
+	got, err = peer(t, addr, client)
+	require.NoError(t, err)
+	require.Equal(t, secondLeaf.Raw, got.Raw)
+}
+
+func TestMutualTLS(t *testing.T) {
+	server := selfSigned(t, "server")
+	client := selfSigned(t, "client")
+	stranger := selfSigned(t, "stranger")
+
+	config, err := ServerConfig(server.cert, server.key, client.cert)
+	require.NoError(t, err)
+	addr := serve(t, config)
+
+	trusted, err := ClientConfig(client.cert, client.key, server.cert)
+	require.NoError(t, err)
+	got, err := peer(t, addr, trusted)
+	require.NoError(t, err)
+	require.Equal(t, leaf(t, server).Raw, got.Raw)
+
+	// no cert and an unknown cert are both refused
+	anonymous, err := ClientConfig("", "", server.cert)
+	require.NoError(t, err)
+	_, err = peer(t, addr, anonymous)
+	require.Error(t, err)
+
+	unknown, err := ClientConfig(stranger.cert, stranger.key, server.cert)
+	require.NoError(t, err)
+	_, err = peer(t, addr, unknown)
+	require.Error(t, err)
+
+	// and the client checks the server just the same
+	suspicious, err := ClientConfig(client.cert, client.key, stranger.cert)
+	require.NoError(t, err)
+	_, err = peer(t, addr, suspicious)
+	var unknownAuthority x509.UnknownAuthorityError
+	require.ErrorAs(t, err, &unknownAuthority)
+}
+
+func TestConfigErrors(t *testing.T) {
+	files := selfSigned(t, "files")
+
+	_, err := ClientConfig(files.cert, "", "")
+	require.Error(t, err)
+
+	_, err = ServerConfig(files.cert, files.key, files.key)
+	require.ErrorIs(t, err, TLSNoCertificates)
+
+	_, err = ServerConfig(files.cert, filepath.Join(t.TempDir(), "missing.pem"), "")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,404 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"vim-arcade.theprimeagen.com/pkg/assert"
+)
+
+var WebSocketHandshakeFailed = fmt.Errorf("websocket handshake failed")
+var WebSocketProtocolError = fmt.Errorf("websocket protocol error")
+
+// RFC 6455 1.3, appended to the client's key for the accept header
+const ACCEPT_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// control frames never carry more than this
+const MAX_CONTROL_PAYLOAD = 125
+
+// how long a close frame gets to go out, a peer that stopped reading does not
+// get to hold up closing
+const CLOSE_WRITE_TIMEOUT = time.Second
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseUnsupported   = 1003
+)
+
+// Conn is a websocket as a byte stream.  Every Write is one binary message,
+// Read hands back the payload of binary messages in order with no regard for
+// where one message ends and the next begins.  Pings are answered while
+// reading, text messages are a protocol error
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// clients mask what they send and servers insist on it
+	client bool
+
+	writeM    sync.Mutex
+	closeOnce sync.Once
+
+	// what is left of the data frame being read
+	remaining  uint64
+	masked     bool
+	mask       [4]byte
+	maskOffset int
+
+	// a binary message without FIN, continuations are only allowed in one
+	fragmented bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		conn:   conn,
+		reader: reader,
+		client: client,
+	}
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + ACCEPT_GUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHas is a case insensitive search through a comma separated header
+func headerHas(header http.Header, name string, value string) bool {
+	for _, line := range header.Values(name) {
+		for _, token := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func handshakeError(format string, args ...any) error {
+	return errors.Join(WebSocketHandshakeFailed, fmt.Errorf(format, args...))
+}
+
+// Accept upgrades the request, on an error the response has already been
+// written.  There is no origin check, anything a browser could be tricked
+// into sending still has to get past the proxy's Authenticator
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a websocket upgrade", http.StatusBadRequest)
+		return nil, handshakeError("not an upgrade: %s %s", r.Method, r.Header.Get("Upgrade"))
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, handshakeError("unsupported version: %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "bad websocket key", http.StatusBadRequest)
+		return nil, handshakeError("bad key: %q", key)
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket upgrade unsupported", http.StatusInternalServerError)
+		return nil, handshakeError("response writer cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.Join(WebSocketHandshakeFailed, err)
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, errors.Join(WebSocketHandshakeFailed, err)
+	}
+
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial opens a websocket to ws://addr/path
+func Dial(ctx context.Context, addr string, path string) (*Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	assert.NoError(err, "unable to read random bytes for a websocket key")
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Join(WebSocketHandshakeFailed, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Join(WebSocketHandshakeFailed, err)
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, handshakeError("unexpected status: %s", rsp.Status)
+	}
+
+	if rsp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, handshakeError("accept key does not match")
+	}
+
+	return newConn(conn, reader, true), nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+
+	n, err := c.reader.Read(b)
+	if c.masked {
+		for i := range n {
+			b[i] ^= c.mask[(c.maskOffset+i)%4]
+		}
+		c.maskOffset = (c.maskOffset + n) % 4
+	}
+
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until there is a data frame to read from.
+// Control frames are dealt with on the way
+func (c *Conn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	if header[0]&0x70 != 0 {
+		return c.fail(CloseProtocolError, "reserved bits set")
+	}
+
+	// the server never masks, the client always does
+	if masked == c.client {
+		return c.fail(CloseProtocolError, fmt.Sprintf("masked frame is %v", masked))
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return c.fail(CloseProtocolError, "payload length has its most significant bit set")
+		}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	if opcode >= opClose {
+		if !fin || length > MAX_CONTROL_PAYLOAD {
+			return c.fail(CloseProtocolError, "control frame is fragmented or too large")
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		return c.control(opcode, payload)
+	}
+
+	switch opcode {
+	case opBinary:
+		if c.fragmented {
+			return c.fail(CloseProtocolError, "binary frame in the middle of a message")
+		}
+	case opContinuation:
+		if !c.fragmented {
+			return c.fail(CloseProtocolError, "continuation without a message")
+		}
+	case opText:
+		return c.fail(CloseUnsupported, "only binary messages are supported")
+	default:
+		return c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+	}
+
+	c.fragmented = !fin
+	c.remaining = length
+	c.masked = masked
+	c.mask = mask
+	c.maskOffset = 0
+	return nil
+}
+
+func (c *Conn) control(opcode byte, payload []byte) error {
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opPong:
+		return nil
+	case opClose:
+		// echo the close back, the other side is done talking
+		code := CloseNormal
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		c.sendClose(code, "")
+		return io.EOF
+	}
+
+	return c.fail(CloseProtocolError, fmt.Sprintf("unknown control opcode %d", opcode))
+}
+
+// fail closes with the code and reason, the returned error is for Read
+func (c *Conn) fail(code int, reason string) error {
+	c.sendClose(code, reason)
+	return errors.Join(WebSocketProtocolError, fmt.Errorf("%s", reason))
+}
+
+// sendClose is only ever sent once, whoever closes first decides the code.
+// Nothing is written after it, so the deadline also cuts off any write stuck
+// behind a peer that stopped reading
+func (c *Conn) sendClose(code int, reason string) {
+	c.closeOnce.Do(func() {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(CLOSE_WRITE_TIMEOUT))
+
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > MAX_CONTROL_PAYLOAD {
+			payload = payload[:MAX_CONTROL_PAYLOAD]
+		}
+		_ = c.writeFrame(opClose, payload)
+	})
+}
+
+// Write sends b as a single binary message
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(opBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|opcode)
+
+	var maskBit byte = 0
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		header = append(header, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	if c.client {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		assert.NoError(err, "unable to read random bytes for a websocket mask")
+		header = append(header, mask[:]...)
+
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+
+	c.writeM.Lock()
+	defer c.writeM.Unlock()
+
+	buffers := net.Buffers{header, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// Close says goodbye with a normal close before closing the connection
+func (c *Conn) Close() error {
+	c.sendClose(CloseNormal, "")
+	return c.conn.Close()
+}
+
+func (c *Conn) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
@@ -0,0 +1,201 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer hands every accepted conn to handle
+func echoServer(t *testing.T, handle func(*Conn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Accept(w, r)
+		if err != nil {
+			return
+		}
+		go handle(conn)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func echo(conn *Conn) {
+	defer conn.Close()
+	io.Copy(conn, conn)
+}
+
+// rawFrame is a client frame written by hand, masked with a zero mask
+func rawFrame(fin bool, opcode byte, masked bool, payload []byte) []byte {
+	var first byte = opcode
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+	var maskBit byte = 0
+	if masked {
+		maskBit = 0x80
+	}
+	frame = append(frame, maskBit|byte(len(payload)))
+	if masked {
+		frame = append(frame, 0, 0, 0, 0)
+	}
+	return append(frame, payload...)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 1.3
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestRoundTrip(t *testing.T) {
+	addr := echoServer(t, echo)
+	conn, err := Dial(context.Background(), addr, "/")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// every length encoding
+	for _, size := range []int{1, 125, 126, 0xFFFF, 0x10000} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		_, err := conn.Write(data)
+		require.NoError(t, err)
+
+		got := make([]byte, size)
+		_, err = io.ReadFull(conn, got)
+		require.NoError(t, err)
+		require.Equal(t, data, got, "size %d", size)
+	}
+}
+
+func TestPingAndClose(t *testing.T) {
+	addr := echoServer(t, echo)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	require.NoError(t, req.Write(conn))
+
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, rsp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", rsp.Header.Get("Sec-WebSocket-Accept"))
+
+	// a fragmented message with a ping in the middle
+	conn.Write(rawFrame(false, opBinary, true, []byte("vim ")))
+	conn.Write(rawFrame(true, opPing, true, []byte("hi")))
+	conn.Write(rawFrame(true, opContinuation, true, []byte("arcade")))
+
+	// the echo writes each piece as it reads it, the pong goes out in between
+	frames := make([]byte, (2+4)+(2+2)+(2+6))
+	_, err = io.ReadFull(reader, frames)
+	require.NoError(t, err)
+	require.Equal(t, append([]byte{0x80 | opBinary, 4}, "vim "...), frames[:6])
+	require.Equal(t, []byte{0x80 | opPong, 2, 'h', 'i'}, frames[6:10])
+	require.Equal(t, append([]byte{0x80 | opBinary, 6}, "arcade"...), frames[10:])
+
+	// the close is echoed back
+	conn.Write(rawFrame(true, opClose, true, binary.BigEndian.AppendUint16(nil, CloseNormal)))
+	closed := make([]byte, 4)
+	_, err = io.ReadFull(reader, closed)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x80 | opClose, 2, 0x03, 0xE8}, closed)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := echoServer(t, func(conn *Conn) {
+		defer conn.Close()
+		_, err := io.ReadAll(conn)
+		errs <- err
+	})
+
+	for name, tc := range map[string]struct {
+		frame []byte
+		code  uint16
+	}{
+		"unmasked":          {rawFrame(true, opBinary, false, []byte("nope")), CloseProtocolError},
+		"text":              {rawFrame(true, opText, true, []byte("nope")), CloseUnsupported},
+		"lone continuation": {rawFrame(true, opContinuation, true, []byte("nope")), CloseProtocolError},
+		"fragmented ping":   {rawFrame(false, opPing, true, nil), CloseProtocolError},
+	} {
+		conn, err := Dial(context.Background(), addr, "/")
+		require.NoError(t, err, name)
+
+		// behind the Conn's back, it would mask and only send binary
+		conn.conn.Write(tc.frame)
+		require.ErrorIs(t, <-errs, WebSocketProtocolError, name)
+
+		header := make([]byte, 4)
+		_, err = io.ReadFull(conn.reader, header)
+		require.NoError(t, err, name)
+		require.Equal(t, byte(0x80|opClose), header[0], name)
+		require.Equal(t, tc.code, binary.BigEndian.Uint16(header[2:]), name)
+		conn.Close()
+	}
+}
+
+func TestAcceptRejectsPlainRequests(t *testing.T) {
+	addr := echoServer(t, echo)
+
+	rsp, err := http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	rsp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusUpgradeRequired, rsp.StatusCode)
+	require.Equal(t, "13", rsp.Header.Get("Sec-WebSocket-Version"))
+}
+
+func TestCloseWithPeerThatStoppedReading(t *testing.T) {
+	closed := make(chan error, 1)
+	addr := echoServer(t, func(conn *Conn) {
+		// write until the socket buffers are full and a write is stuck
+		go func() {
+			chunk := make([]byte, 1<<20)
+			for {
+				if _, err := conn.Write(chunk); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Millisecond * 100)
+		closed <- conn.Close()
+	})
+
+	// dialed and never read from
+	conn, err := Dial(context.Background(), addr, "/")
+	require.NoError(t, err)
+	defer conn.conn.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(CLOSE_WRITE_TIMEOUT * 3):
+		require.FailNow(t, "close waited on a peer that stopped reading")
+	}
+}